@@ -0,0 +1,151 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// parts of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"nspeed.app/nspeed/network"
+)
+
+// exit codes
+const (
+	exitOK      = 0 // no query failed
+	exitFailure = 1 // some queries failed (timeout, SERVFAIL ...)
+	exitError   = 2 // other errors
+)
+
+// a sample program to demo the DNS benchmark of the nspeed.app/network package
+// it resolves names against several DNS servers and compares the latencies.
+// servers are given like network.SetDNSServer accepts them: IP[:port] (UDP),
+// tcp://IP[:port], tls://host[:port] or https://host[/path]
+// for instance:
+//
+//	dnsbench -s 1.1.1.1,8.8.8.8,tls://one.one.one.one,https://dns.google nspeed.app google.com
+//	dnsbench -s 192.168.1.1,9.9.9.9 -c 5 -t A,AAAA,MX -o json -f names.txt
+func main() {
+
+	var s = flag.String("s", "", `comma separated list of DNS servers`)
+	var f = flag.String("f", "", `file of names to resolve, one per line (# for comments)`)
+	var c = flag.Int("c", 3, `number of rounds, the first one is usually not cached by the servers`)
+	var i = flag.Duration("i", 0, `delay between rounds`)
+	var w = flag.Duration("w", 0, `time to wait for an answer (default 5s)`)
+	var t = flag.String("t", "A,AAAA", `comma separated list of query types`)
+	var o = flag.String("o", "text", `output format: text or json (JSON lines)`)
+	var v = flag.Bool("v", false, `print every query`)
+
+	flag.Usage = func() {
+		name := "dnsbench"
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n\n", name)
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s -s server,... [options] name ...\n\n", name)
+		fmt.Fprintf(flag.CommandLine.Output(), "server can be IP[:port], tcp://IP[:port], tls://host[:port] or https://host[/path]\n\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "Available options:\n\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+	}
+	flag.Parse()
+
+	if *s == "" {
+		fmt.Fprintln(os.Stderr, "no DNS server")
+		flag.Usage()
+		os.Exit(exitError)
+	}
+	servers := strings.Split(*s, ",")
+	for _, server := range servers {
+		if _, err := network.ParseDNSServer(server); err != nil {
+			fatal(err)
+		}
+	}
+	if *c < 1 {
+		fatal("invalid count:", *c)
+	}
+	options := network.DNSBenchOptions{Count: *c, Interval: *i, Timeout: *w}
+	for _, name := range strings.Split(*t, ",") {
+		qtype, err := parseType(name)
+		if err != nil {
+			fatal(err)
+		}
+		options.Types = append(options.Types, qtype)
+	}
+	names := flag.Args()
+	if *f != "" {
+		fileNames, err := readNames(*f)
+		if err != nil {
+			fatal(err)
+		}
+		names = append(names, fileNames...)
+	}
+	if len(names) == 0 {
+		fmt.Fprintln(os.Stderr, "no name to resolve")
+		flag.Usage()
+		os.Exit(exitError)
+	}
+	out, err := newPrinter(*o, os.Stdout)
+	if err != nil {
+		fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var report func(network.DNSBenchQuery)
+	if *v {
+		report = out.query
+	}
+	stats, err := network.DNSBench(ctx, servers, names, options, report)
+	if err != nil && ctx.Err() == nil {
+		fatal(err)
+	}
+	out.summary(stats)
+	out.flush()
+	for _, st := range stats {
+		if st.Failures > 0 {
+			os.Exit(exitFailure)
+		}
+	}
+	os.Exit(exitOK)
+}
+
+// parseType parses a query type name (A, AAAA, MX ...)
+func parseType(s string) (dnsmessage.Type, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	for t := dnsmessage.Type(1); t < 256; t++ {
+		if strings.TrimPrefix(t.String(), "Type") == s {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid query type: %s", s)
+}
+
+// readNames reads the names of a file, one per line
+func readNames(file string) ([]string, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	var names []string
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if line = strings.TrimSpace(line); line != "" {
+			names = append(names, line)
+		}
+	}
+	return names, scanner.Err()
+}
+
+// fatal prints an error about the command line and exits
+func fatal(a ...any) {
+	fmt.Fprintln(os.Stderr, a...)
+	os.Exit(exitError)
+}
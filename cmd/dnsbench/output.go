@@ -0,0 +1,191 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"nspeed.app/nspeed/network"
+)
+
+// printer prints the queries and the statistics of the benchmark
+type printer interface {
+	query(q network.DNSBenchQuery)
+	summary(stats []network.DNSBenchStats)
+	flush()
+}
+
+// newPrinter returns the printer of format ("text" or "json") writing to w
+func newPrinter(format string, w io.Writer) (printer, error) {
+	switch format {
+	case "text":
+		return &textPrinter{w: w}, nil
+	case "json":
+		return &jsonPrinter{enc: json.NewEncoder(w)}, nil
+	default:
+		return nil, fmt.Errorf("invalid output format: %s", format)
+	}
+}
+
+// queryRecord is a query in json output
+type queryRecord struct {
+	Type      string   `json:"type"` // "query"
+	Server    string   `json:"server"`
+	Round     int      `json:"round"`
+	Name      string   `json:"name"`
+	QueryType string   `json:"query_type"`
+	Time      string   `json:"time"` // RFC 3339
+	RCode     string   `json:"rcode,omitempty"`
+	Addresses []string `json:"addresses,omitempty"`
+	CNAMEs    []string `json:"cnames,omitempty"`
+	TTL       float64  `json:"ttl_s"`
+	Truncated bool     `json:"truncated,omitempty"`
+	Connect   float64  `json:"connect_ms"`
+	RTT       float64  `json:"rtt_ms"`
+	Size      int      `json:"size"`
+	Error     string   `json:"error,omitempty"`
+}
+
+func newQueryRecord(q network.DNSBenchQuery) queryRecord {
+	r := q.Result
+	rec := queryRecord{
+		Type:      "query",
+		Server:    r.Server.String(),
+		Round:     q.Round,
+		Name:      r.Name,
+		QueryType: typeName(r.Type),
+		Time:      r.Start.Format(time.RFC3339Nano),
+		CNAMEs:    r.CNAMEs,
+		TTL:       r.TTL.Seconds(),
+		Truncated: r.Truncated,
+		Connect:   ms(r.Connect),
+		RTT:       ms(r.RTT),
+		Size:      r.Size,
+	}
+	if q.Err != nil {
+		rec.Error = q.Err.Error()
+	} else {
+		rec.RCode = rcodeName(r.RCode)
+	}
+	for _, a := range r.Addresses {
+		rec.Addresses = append(rec.Addresses, a.String())
+	}
+	return rec
+}
+
+// summaryRecord is the statistics of a server and query type in json output
+type summaryRecord struct {
+	Type      string  `json:"type"` // "summary"
+	Server    string  `json:"server"`
+	QueryType string  `json:"query_type"`
+	Queries   int     `json:"queries"`
+	Failures  int     `json:"failures"`
+	Failure   float64 `json:"failure_pct"`
+	NotFound  int     `json:"nxdomain"`
+	NoData    int     `json:"nodata"`
+	Answered  int     `json:"answered"`
+	CacheHits int     `json:"cache_hits"`
+	Min       float64 `json:"min_ms"`
+	Avg       float64 `json:"avg_ms"`
+	Max       float64 `json:"max_ms"`
+	P50       float64 `json:"p50_ms"`
+	P90       float64 `json:"p90_ms"`
+	First     float64 `json:"first_ms"`
+	Repeated  float64 `json:"repeated_ms"`
+	Connect   float64 `json:"connect_ms"`
+}
+
+func newSummaryRecord(s network.DNSBenchStats) summaryRecord {
+	return summaryRecord{
+		Type:      "summary",
+		Server:    s.Server.String(),
+		QueryType: typeName(s.Type),
+		Queries:   s.Queries,
+		Failures:  s.Failures,
+		Failure:   s.FailureRate(),
+		NotFound:  s.NotFound,
+		NoData:    s.NoData,
+		Answered:  s.Answered,
+		CacheHits: s.CacheHits,
+		Min:       ms(s.Min),
+		Avg:       ms(s.Avg),
+		Max:       ms(s.Max),
+		P50:       ms(s.P50),
+		P90:       ms(s.P90),
+		First:     ms(s.First),
+		Repeated:  ms(s.Repeated),
+		Connect:   ms(s.Connect),
+	}
+}
+
+// textPrinter prints a line per query and a table of the statistics
+type textPrinter struct {
+	w io.Writer
+}
+
+func (p *textPrinter) query(q network.DNSBenchQuery) {
+	r := q.Result
+	if q.Err != nil {
+		fmt.Fprintf(p.w, "%s %s %s: %v\n", r.Server, typeName(r.Type), r.Name, q.Err)
+		return
+	}
+	answer := rcodeName(r.RCode)
+	if len(r.Addresses) > 0 {
+		answer = fmt.Sprint(r.Addresses)
+	}
+	fmt.Fprintf(p.w, "%s %s %s: %s ttl=%s time=%.3f ms\n", r.Server, typeName(r.Type), r.Name, answer, r.TTL, ms(r.RTT))
+}
+
+func (p *textPrinter) summary(stats []network.DNSBenchStats) {
+	tw := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "server\ttype\tqueries\tfail%\tnxdomain\tnodata\tanswered\tmin\tavg\tp50\tp90\tmax\tfirst\trepeated\tcached\t")
+	for _, s := range stats {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%.1f\t%d\t%d\t%d\t%.3f\t%.3f\t%.3f\t%.3f\t%.3f\t%.3f\t%.3f\t%d\t\n",
+			s.Server, typeName(s.Type), s.Queries, s.FailureRate(), s.NotFound, s.NoData, s.Answered,
+			ms(s.Min), ms(s.Avg), ms(s.P50), ms(s.P90), ms(s.Max), ms(s.First), ms(s.Repeated), s.CacheHits)
+	}
+	tw.Flush()
+	fmt.Fprintln(p.w, "times in ms, first: average of the first round (uncached), repeated: average of the other rounds")
+}
+
+func (p *textPrinter) flush() {}
+
+// jsonPrinter prints JSON lines
+type jsonPrinter struct {
+	enc *json.Encoder
+}
+
+func (p *jsonPrinter) query(q network.DNSBenchQuery) {
+	_ = p.enc.Encode(newQueryRecord(q))
+}
+
+func (p *jsonPrinter) summary(stats []network.DNSBenchStats) {
+	for _, s := range stats {
+		_ = p.enc.Encode(newSummaryRecord(s))
+	}
+}
+
+func (p *jsonPrinter) flush() {}
+
+// typeName returns the name of a query type without the "Type" prefix
+func typeName(t dnsmessage.Type) string {
+	return strings.TrimPrefix(t.String(), "Type")
+}
+
+// rcodeName returns the name of a response code without the "RCode" prefix
+func rcodeName(c dnsmessage.RCode) string {
+	return strings.TrimPrefix(c.String(), "RCode")
+}
+
+// ms converts a duration to float milliseconds (rounded to the µs)
+func ms(d time.Duration) float64 {
+	return float64(d.Round(time.Microsecond)) / float64(time.Millisecond)
+}
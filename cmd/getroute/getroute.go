@@ -8,7 +8,9 @@ package main
 import (
 	"flag"
 	"fmt"
+	"net/netip"
 	"os"
+	"strconv"
 
 	"nspeed.app/nspeed/network"
 )
@@ -17,18 +19,27 @@ import (
 // it's equivalent to "ip route get target" command line on Linux but
 // target can be a hostname/fqdn
 // multiple targets can be used
+// with -all, it prints the routing table like "ip route show" (Linux only)
 // for instance:
 //
 //	getroute dns.google one.one.one.one
+//	getroute -all -6 -table all
+//	getroute -from 192.168.1.10 -dev eth1 -mark 0x10 dns.google
 func main() {
 
 	var v4 = flag.Bool("4", false, `use IPv4`)
 	var v6 = flag.Bool("6", false, `use IPv6`)
+	var all = flag.Bool("all", false, `print the routing table instead of the route of targets`)
+	var from = flag.String("from", "", `source address of the route lookup (policy routing, Linux only)`)
+	var dev = flag.String("dev", "", `outbound interface of the route lookup (policy routing, Linux only)`)
+	var mark = flag.String("mark", "", `socket mark (fwmark) of the route lookup (policy routing, Linux only)`)
+	var table = flag.String("table", "main", `routing table printed by -all: main, local, default, all or a number`)
 
 	flag.Usage = func() {
 		name := "getroute"
 		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n\n", name)
-		fmt.Fprintf(flag.CommandLine.Output(), "  %s [options] target ...\n\n", name)
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s [options] target ...\n", name)
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s -all [-4|-6] [-table table]\n\n", name)
 		fmt.Fprintf(flag.CommandLine.Output(), "target can be an IP address or a DNS name\n\n")
 		fmt.Fprintf(flag.CommandLine.Output(), "Available options:\n\n")
 		flag.PrintDefaults()
@@ -48,6 +59,42 @@ func main() {
 		ipVersion = 6
 	}
 
+	var options network.RouteOptions
+	if *from != "" {
+		src, err := netip.ParseAddr(*from)
+		if err != nil {
+			fmt.Println("invalid source address:", err)
+			os.Exit(1)
+		}
+		options.Source = src
+	}
+	options.Interface = *dev
+	if *mark != "" {
+		m, err := strconv.ParseUint(*mark, 0, 32)
+		if err != nil {
+			fmt.Println("invalid mark:", err)
+			os.Exit(1)
+		}
+		options.Mark = uint32(m)
+	}
+
+	if *all {
+		t, err := network.ParseRouteTable(*table)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		routes, err := network.GetRoutes(ipVersion, t)
+		if err != nil {
+			fmt.Println("GetRoutes error:", err)
+			os.Exit(1)
+		}
+		for _, r := range routes {
+			fmt.Println(r)
+		}
+		return
+	}
+
 	if flag.NArg() == 0 {
 		fmt.Println("no target")
 		flag.Usage()
@@ -70,6 +117,15 @@ func main() {
 		// loop thru addresses of the target
 		for _, a := range addrf {
 			fmt.Printf("%s = %s:\n", v, a.String())
+			if options != (network.RouteOptions{}) {
+				r, err := network.GetRouteWithOptions(a.String(), options)
+				if err != nil {
+					fmt.Println("GetRouteWithOptions error:", err)
+					continue
+				}
+				fmt.Println(" ", r)
+				continue
+			}
 			iface, gw, src, err := network.GetRoute(a.String())
 			if err != nil {
 				fmt.Println("GetRoute error:", err)
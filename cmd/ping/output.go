@@ -0,0 +1,273 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"nspeed.app/nspeed/ping"
+)
+
+// printer prints the probes and the summary of the ping sessions, or the path MTU (-pmtu)
+type printer interface {
+	start(target string, addr netip.Addr, options ping.SessionOptions)
+	probe(target string, addr netip.Addr, r ping.ProbeResult)
+	summary(target string, addr netip.Addr, s ping.Statistics)
+	pmtu(target string, r *ping.PMTUResult)
+	flush()
+}
+
+// newPrinter returns the printer of format ("text", "json" or "csv") writing to w
+func newPrinter(format string, w io.Writer) (printer, error) {
+	switch format {
+	case "text":
+		return &textPrinter{w: w}, nil
+	case "json":
+		return &jsonPrinter{enc: json.NewEncoder(w)}, nil
+	case "csv":
+		return &csvPrinter{w: csv.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("invalid output format: %s", format)
+	}
+}
+
+// probeRecord is a probe in json and csv outputs
+type probeRecord struct {
+	Type         string  `json:"type"` // "probe"
+	Target       string  `json:"target"`
+	Address      string  `json:"address"`
+	Seq          int     `json:"seq"`
+	Time         string  `json:"time"` // time the probe was sent, RFC 3339
+	RTT          float64 `json:"rtt_ms"`
+	TTL          int     `json:"ttl,omitempty"`
+	Bytes        int     `json:"bytes,omitempty"`
+	TrafficClass int     `json:"tos"` // -1 if unknown
+	Response     string  `json:"response"`
+	From         string  `json:"from,omitempty"`
+	Duplicate    bool    `json:"duplicate,omitempty"`
+	Lost         bool    `json:"lost,omitempty"`
+}
+
+func newProbeRecord(target string, addr netip.Addr, r ping.ProbeResult) probeRecord {
+	p := probeRecord{
+		Type:         "probe",
+		Target:       target,
+		Address:      addr.String(),
+		Seq:          r.Seq,
+		Time:         r.Sent.Format(time.RFC3339Nano),
+		RTT:          ms(r.RTT),
+		TTL:          r.TTL,
+		Bytes:        r.Bytes,
+		TrafficClass: r.TrafficClass,
+		Duplicate:    r.Duplicate,
+		Lost:         r.Lost,
+		Response:     "lost",
+	}
+	if r.ICMP != nil {
+		p.Response = r.ICMP.Reason
+		p.From = r.ICMP.From.String()
+	}
+	return p
+}
+
+// summaryRecord is a summary in json and csv outputs
+type summaryRecord struct {
+	Type        string  `json:"type"` // "summary"
+	Target      string  `json:"target"`
+	Address     string  `json:"address"`
+	Transmitted int     `json:"transmitted"`
+	Received    int     `json:"received"`
+	Duplicates  int     `json:"duplicates"`
+	Errors      int     `json:"errors"`
+	Loss        float64 `json:"loss_pct"`
+	Elapsed     float64 `json:"elapsed_ms"`
+	Min         float64 `json:"min_ms"`
+	Avg         float64 `json:"avg_ms"`
+	Max         float64 `json:"max_ms"`
+	Mdev        float64 `json:"mdev_ms"`
+	Jitter      float64 `json:"jitter_ms"`
+	IPDV        float64 `json:"ipdv_ms"`
+	P50         float64 `json:"p50_ms"`
+	P90         float64 `json:"p90_ms"`
+	P99         float64 `json:"p99_ms"`
+}
+
+func newSummaryRecord(target string, addr netip.Addr, s ping.Statistics) summaryRecord {
+	return summaryRecord{
+		Type:        "summary",
+		Target:      target,
+		Address:     addr.String(),
+		Transmitted: s.Transmitted,
+		Received:    s.Received,
+		Duplicates:  s.Duplicates,
+		Errors:      s.Errors,
+		Loss:        s.PacketLoss(),
+		Elapsed:     ms(s.Elapsed),
+		Min:         ms(s.Min),
+		Avg:         ms(s.Avg),
+		Max:         ms(s.Max),
+		Mdev:        ms(s.Mdev),
+		Jitter:      ms(s.Jitter.Interarrival),
+		IPDV:        ms(s.Jitter.IPDV),
+		P50:         ms(s.Jitter.P50),
+		P90:         ms(s.Jitter.P90),
+		P99:         ms(s.Jitter.P99),
+	}
+}
+
+// pmtuRecord is a path MTU in json and csv outputs
+type pmtuRecord struct {
+	Type     string `json:"type"` // "pmtu"
+	Target   string `json:"target"`
+	Address  string `json:"address"`
+	MTU      int    `json:"mtu"`
+	Reporter string `json:"reporter,omitempty"`
+	Probes   int    `json:"probes"`
+}
+
+func newPMTURecord(target string, r *ping.PMTUResult) pmtuRecord {
+	p := pmtuRecord{
+		Type:    "pmtu",
+		Target:  target,
+		Address: r.Destination.String(),
+		MTU:     r.MTU,
+		Probes:  r.Probes,
+	}
+	if r.Reporter.IsValid() {
+		p.Reporter = r.Reporter.String()
+	}
+	return p
+}
+
+// textPrinter prints like iputils ping
+type textPrinter struct {
+	w io.Writer
+}
+
+func (p *textPrinter) start(target string, addr netip.Addr, options ping.SessionOptions) {
+	fmt.Fprintf(p.w, "PING %s (%s) %d bytes of data.\n", target, addr, max(int(options.PacketSize)-1, 0))
+}
+
+func (p *textPrinter) probe(target string, addr netip.Addr, r ping.ProbeResult) {
+	switch {
+	case r.Lost:
+		fmt.Fprintf(p.w, "no answer for icmp_seq=%d\n", r.Seq+1)
+	case r.Response == ping.PingResponseEchoReply:
+		dup := ""
+		if r.Duplicate {
+			dup = " (DUP!)"
+		}
+		fmt.Fprintf(p.w, "%d bytes from %s: icmp_seq=%d ttl=%d time=%.3f ms%s\n", r.Bytes, r.Peer, r.Seq+1, r.TTL, ms(r.RTT), dup)
+	default:
+		fmt.Fprintf(p.w, "From %s icmp_seq=%d %s\n", r.Peer, r.Seq+1, r.ICMP.Reason)
+	}
+}
+
+func (p *textPrinter) summary(target string, addr netip.Addr, s ping.Statistics) {
+	fmt.Fprintf(p.w, "\n--- %s ping statistics ---\n%s\n", target, s)
+	if s.Received > 1 {
+		fmt.Fprintf(p.w, "%s\n", s.Jitter)
+	}
+}
+
+func (p *textPrinter) pmtu(target string, r *ping.PMTUResult) {
+	fmt.Fprintln(p.w, target, "ip is", r.Destination, "path MTU:", r.MTU, "probes:", r.Probes)
+}
+
+func (p *textPrinter) flush() {}
+
+// jsonPrinter prints JSON lines
+type jsonPrinter struct {
+	enc *json.Encoder
+}
+
+func (p *jsonPrinter) start(target string, addr netip.Addr, options ping.SessionOptions) {}
+
+func (p *jsonPrinter) probe(target string, addr netip.Addr, r ping.ProbeResult) {
+	_ = p.enc.Encode(newProbeRecord(target, addr, r))
+}
+
+func (p *jsonPrinter) summary(target string, addr netip.Addr, s ping.Statistics) {
+	_ = p.enc.Encode(newSummaryRecord(target, addr, s))
+}
+
+func (p *jsonPrinter) pmtu(target string, r *ping.PMTUResult) {
+	_ = p.enc.Encode(newPMTURecord(target, r))
+}
+
+func (p *jsonPrinter) flush() {}
+
+// csvPrinter prints a single CSV table: the probes and the summaries are rows
+// of type "probe" and "summary" with their own columns, the other ones are empty.
+// The path MTU rows (type "pmtu") are a table of their own, -pmtu doesn't ping.
+type csvPrinter struct {
+	w      *csv.Writer
+	header bool
+}
+
+var csvProbeColumns = []string{"seq", "time", "rtt_ms", "ttl", "bytes", "tos", "response", "from", "duplicate", "lost"}
+var csvSummaryColumns = []string{"transmitted", "received", "duplicates", "errors", "loss_pct", "elapsed_ms",
+	"min_ms", "avg_ms", "max_ms", "mdev_ms", "jitter_ms", "ipdv_ms", "p50_ms", "p90_ms", "p99_ms"}
+var csvPMTUColumns = []string{"type", "target", "address", "mtu", "reporter", "probes"}
+
+func (p *csvPrinter) start(target string, addr netip.Addr, options ping.SessionOptions) {
+	if p.header {
+		return
+	}
+	p.header = true
+	header := append([]string{"type", "target", "address"}, csvProbeColumns...)
+	_ = p.w.Write(append(header, csvSummaryColumns...))
+}
+
+func (p *csvPrinter) probe(target string, addr netip.Addr, r ping.ProbeResult) {
+	rec := newProbeRecord(target, addr, r)
+	row := []string{rec.Type, rec.Target, rec.Address,
+		strconv.Itoa(rec.Seq), rec.Time, formatFloat(rec.RTT), strconv.Itoa(rec.TTL), strconv.Itoa(rec.Bytes),
+		strconv.Itoa(rec.TrafficClass), rec.Response, rec.From, strconv.FormatBool(rec.Duplicate), strconv.FormatBool(rec.Lost)}
+	_ = p.w.Write(append(row, make([]string, len(csvSummaryColumns))...))
+	p.w.Flush()
+}
+
+func (p *csvPrinter) summary(target string, addr netip.Addr, s ping.Statistics) {
+	rec := newSummaryRecord(target, addr, s)
+	row := append([]string{rec.Type, rec.Target, rec.Address}, make([]string, len(csvProbeColumns))...)
+	row = append(row, strconv.Itoa(rec.Transmitted), strconv.Itoa(rec.Received), strconv.Itoa(rec.Duplicates),
+		strconv.Itoa(rec.Errors), formatFloat(rec.Loss), formatFloat(rec.Elapsed))
+	for _, v := range []float64{rec.Min, rec.Avg, rec.Max, rec.Mdev, rec.Jitter, rec.IPDV, rec.P50, rec.P90, rec.P99} {
+		row = append(row, formatFloat(v))
+	}
+	_ = p.w.Write(row)
+	p.w.Flush()
+}
+
+func (p *csvPrinter) pmtu(target string, r *ping.PMTUResult) {
+	if !p.header {
+		p.header = true
+		_ = p.w.Write(csvPMTUColumns)
+	}
+	rec := newPMTURecord(target, r)
+	_ = p.w.Write([]string{rec.Type, rec.Target, rec.Address, strconv.Itoa(rec.MTU), rec.Reporter, strconv.Itoa(rec.Probes)})
+	p.w.Flush()
+}
+
+func (p *csvPrinter) flush() {
+	p.w.Flush()
+}
+
+// formatFloat formats a float with the shortest representation
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// ms converts a duration to float milliseconds (rounded to the µs)
+func ms(d time.Duration) float64 {
+	return float64(d.Round(time.Microsecond)) / float64(time.Millisecond)
+}
@@ -6,28 +6,72 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"time"
 
+	"nspeed.app/nspeed/network"
 	"nspeed.app/nspeed/ping"
 )
 
+// minInterval is the smallest delay between probes (flood protection)
+const minInterval = 10 * time.Millisecond
+
+// exit codes (same as iputils ping)
+const (
+	exitOK    = 0 // all targets replied within the loss threshold
+	exitLoss  = 1 // a target didn't reply or its packet loss exceeds -max-loss
+	exitError = 2 // other errors
+)
+
+// a sample program to demo the nspeed.app/ping package
+// it's equivalent to the "ping target" command line.
+// for instance:
+//
+//	ping -c 10 -i 200ms dns.google
+//	ping -c 20 -q -o json -max-loss 5 one.one.one.one
+//
+// targets are pinged one after another, the exit code is 1 if a target
+// has no reply or a packet loss above -max-loss, 2 on errors.
 func main() {
 
+	var c = flag.Int("c", 0, `stop after sending count probes (default 0 = until interrupted)`)
+	var i = flag.Duration("i", ping.DefaultInterval, fmt.Sprintf(`delay between probes (min %s)`, minInterval))
+	var q = flag.Bool("q", false, `quiet output: only the summary`)
+	var o = flag.String("o", "text", `output format: text, json (JSON lines) or csv`)
+	var maxLoss = flag.Float64("max-loss", -1, `exit with code 1 if the packet loss (%) is above this value (default: only if no reply)`)
 	var m = flag.Int("m", 0, `Set the max time-to-live (max number of hops) used in outgoing probe packets (default is 0 = OS default)`)
-	var s = flag.Uint("s", 0, `Set the packet size to use (default is 0 = no timeout)`)
-	var w = flag.Duration("w", 0, `Set timeout (default none)`)
+	var s = flag.Uint("s", 0, `Set the packet size to use (default is 0 = minimum size)`)
+	var w = flag.Duration("w", ping.DefaultTimeout, `time to wait for a reply`)
 	var v4 = flag.Bool("4", false, `use IPv4`)
 	var v6 = flag.Bool("6", false, `use IPv6`)
+	var pmtu = flag.Bool("pmtu", false, `discover the path MTU instead of pinging`)
+	var dscp = flag.String("dscp", "", `DSCP marking of the probes: name (EF, AF41, CS1, ...) or value`)
+	var ecn = flag.String("ecn", "", `ECN marking of the probes: not-ect, ect0, ect1, ce or value`)
+	var mode = flag.String("mode", "privileged", `ICMP socket mode: privileged (raw socket), unprivileged (datagram socket) or auto`)
 
+	flag.Usage = func() {
+		name := "ping"
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n\n", name)
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s [options] target ...\n\n", name)
+		fmt.Fprintf(flag.CommandLine.Output(), "target can be an IP address or a DNS name\n\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "Available options:\n\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+	}
 	flag.Parse()
 
-	options := ping.PingOptions{HopLimit: *m, Timeout: *w}
+	options := ping.SessionOptions{
+		PingOptions: ping.PingOptions{HopLimit: *m, Timeout: *w},
+		Count:       *c,
+		Interval:    *i,
+	}
 	// additionnal flags checks
 	if *v4 && *v6 {
-		fmt.Println("cannot specify both ip version at the same time")
-		os.Exit(1)
+		fatal("cannot specify both ip version at the same time")
 	}
 	if *v4 {
 		options.Version = 4
@@ -36,22 +80,101 @@ func main() {
 		options.Version = 6
 	}
 	if *s > ping.PacketSizeMax {
-		fmt.Println("size if too big, max is", ping.PacketSizeMax)
-		os.Exit(1)
+		fatal("size if too big, max is", ping.PacketSizeMax)
 	}
 	options.PacketSize = uint16(*s)
+	if *i < minInterval {
+		fatal("interval is too short, min is", minInterval)
+	}
+	if *c < 0 {
+		fatal("invalid count:", *c)
+	}
+	var err error
+	if options.Mode, err = ping.ParseSocketMode(*mode); err != nil {
+		fatal(err)
+	}
+	var dscpValue, ecnValue int
+	if *dscp != "" {
+		if dscpValue, err = network.ParseDSCP(*dscp); err != nil {
+			fatal(err)
+		}
+	}
+	if *ecn != "" {
+		if ecnValue, err = network.ParseECN(*ecn); err != nil {
+			fatal(err)
+		}
+	}
+	options.TrafficClass = network.NewTrafficClass(dscpValue, ecnValue)
+	out, err := newPrinter(*o, os.Stdout)
+	if err != nil {
+		fatal(err)
+	}
 
 	if flag.NArg() == 0 {
-		fmt.Println("no target")
-		os.Exit(1)
+		fmt.Fprintln(os.Stderr, "no target")
+		flag.Usage()
+		os.Exit(exitError)
 	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	code := exitOK
 	for _, host := range flag.Args() {
+		if *pmtu {
+			r, err := ping.DiscoverPathMTU(host, ping.PMTUOptions{Version: options.Version, Timeout: options.Timeout})
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "path MTU error:", err)
+				code = exitError
+			} else {
+				out.pmtu(host, r)
+			}
+			continue
+		}
 
-		peer, ping, response, err := ping.Ping(host, options)
+		session, err := ping.NewSession(host, options)
 		if err != nil {
-			fmt.Println("ping error:", err)
-		} else {
-			fmt.Println(host, "ip is", peer, "time: ", ping, "code:", response)
+			fmt.Fprintln(os.Stderr, "ping error:", err)
+			code = exitError
+			continue
+		}
+		out.start(host, session.Addr(), options)
+		var report func(ping.ProbeResult)
+		if !*q {
+			report = func(r ping.ProbeResult) {
+				out.probe(host, session.Addr(), r)
+			}
+		}
+		// an interrupt stops sending, the replies of the probes in flight are still waited for
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				session.Stop()
+			case <-done:
+			}
+		}()
+		stats, err := session.RunContext(context.Background(), report)
+		close(done)
+		_ = session.Close()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ping error:", err)
+			code = exitError
+		}
+		out.summary(host, session.Addr(), stats)
+		if code == exitOK && (stats.Received == 0 || (*maxLoss >= 0 && stats.PacketLoss() > *maxLoss)) {
+			code = exitLoss
+		}
+		if ctx.Err() != nil {
+			break
 		}
 	}
+	out.flush()
+	os.Exit(code)
+}
+
+// fatal prints an error about the command line and exits
+func fatal(a ...any) {
+	fmt.Fprintln(os.Stderr, a...)
+	os.Exit(exitError)
 }
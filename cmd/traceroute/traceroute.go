@@ -0,0 +1,130 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// parts of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"nspeed.app/nspeed/ping"
+)
+
+// a sample program to demo the traceroute & mtr parts of the nspeed.app/ping package
+// it's equivalent to the "traceroute target" command line or,
+// with -mtr, to the "mtr -r target" command line.
+// for instance:
+//
+//	traceroute -P tcp -p 443 dns.google
+//	traceroute -mtr -c 10 one.one.one.one
+func main() {
+
+	var P = flag.String("P", "icmp", `probe type: icmp, udp or tcp`)
+	var p = flag.Int("p", 0, fmt.Sprintf(`destination port (default %d for udp (incremented for each probe), %d for tcp)`, ping.DefaultUDPPort, ping.DefaultTCPPort))
+	var f = flag.Int("f", 1, `first hop (TTL)`)
+	var m = flag.Int("m", ping.DefaultMaxHops, `max hops (max TTL)`)
+	var q = flag.Int("q", ping.DefaultQueries, `number of probes per hop`)
+	var s = flag.Uint("s", 0, `packet size of icmp and udp probes`)
+	var w = flag.Duration("w", ping.DefaultTimeout, `time to wait for a response`)
+	var v4 = flag.Bool("4", false, `use IPv4`)
+	var v6 = flag.Bool("6", false, `use IPv6`)
+	var mtr = flag.Bool("mtr", false, `continuously probe the path (like mtr) and report per hop statistics`)
+	var c = flag.Int("c", 0, `number of rounds with -mtr (default 0 = until interrupted)`)
+	var i = flag.Duration("i", ping.DefaultInterval, `delay between rounds with -mtr`)
+
+	flag.Usage = func() {
+		name := "traceroute"
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n\n", name)
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s [options] target ...\n\n", name)
+		fmt.Fprintf(flag.CommandLine.Output(), "target can be an IP address or a DNS name\n\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "Available options:\n\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+	}
+	flag.Parse()
+
+	options := ping.TracerouteOptions{
+		Port:     *p,
+		FirstHop: *f,
+		MaxHops:  *m,
+		Queries:  *q,
+		Timeout:  *w,
+		Interval: *i,
+		Rounds:   *c,
+	}
+	var err error
+	if options.Probe, err = ping.ParseProbeType(*P); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if *v4 && *v6 {
+		fmt.Println("cannot specify both IP version at the same time")
+		os.Exit(1)
+	}
+	if *v4 {
+		options.Version = 4
+	}
+	if *v6 {
+		options.Version = 6
+	}
+	if *s > ping.PacketSizeMax {
+		fmt.Println("size if too big, max is", ping.PacketSizeMax)
+		os.Exit(1)
+	}
+	options.PacketSize = uint16(*s)
+
+	if flag.NArg() == 0 {
+		fmt.Println("no target")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	for _, host := range flag.Args() {
+		if !*mtr {
+			trace, err := ping.Traceroute(host, options)
+			if err != nil {
+				fmt.Println("traceroute error:", err)
+				continue
+			}
+			fmt.Printf("traceroute to %s (%s), %d hops max, %s probes\n", host, trace.Destination, options.MaxHops, options.Probe)
+			fmt.Print(trace)
+			continue
+		}
+		stats, err := ping.MTR(ctx, host, options, nil)
+		if err != nil {
+			fmt.Println("mtr error:", err)
+			continue
+		}
+		fmt.Printf("mtr to %s, %s probes\n", host, options.Probe)
+		fmt.Printf("%3s %-40s %6s %5s %8s %8s %8s %8s %8s\n", "", "Host", "Loss%", "Snt", "Last", "Avg", "Best", "Wrst", "Jitter")
+		for _, h := range stats {
+			var peers []string
+			for _, a := range h.Peers {
+				peers = append(peers, a.String())
+			}
+			host := strings.Join(peers, " ")
+			if host == "" {
+				host = "???"
+			}
+			fmt.Printf("%3d %-40s %5.1f%% %5d %8.1f %8.1f %8.1f %8.1f %8.1f\n", h.TTL, host, h.PacketLoss(), h.Transmitted,
+				ms(h.Last), ms(h.Avg), ms(h.Min), ms(h.Max), ms(h.Jitter.IPDV))
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+}
+
+// ms converts a duration to float milliseconds
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -7,11 +7,11 @@ require (
 	github.com/libp2p/go-netroute v0.4.0
 	github.com/rs/zerolog v1.34.0
 	golang.org/x/net v0.49.0
+	golang.org/x/sys v0.40.0
 )
 
 require (
 	github.com/mattn/go-colorable v0.1.14 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	golang.org/x/crypto v0.47.0 // indirect
-	golang.org/x/sys v0.40.0 // indirect
 )
@@ -0,0 +1,259 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"syscall"
+
+	"golang.org/x/net/quic"
+)
+
+// ErrBindToDevice is returned (wrapped) when a socket can't be bound to an interface with SO_BINDTODEVICE:
+// it's only supported on Linux and requires the CAP_NET_RAW capability (root) before Linux 5.7.
+var ErrBindToDevice = errors.New("cannot bind to device")
+
+// BindMode is how the sockets are bound to an interface
+type BindMode int
+
+const (
+	BindAuto    BindMode = iota // SO_BINDTODEVICE if permitted, else the address of the interface
+	BindDevice                  // SO_BINDTODEVICE only, fails with ErrBindToDevice if not permitted
+	BindAddress                 // the address of the interface only
+)
+
+func (m BindMode) String() string {
+	switch m {
+	case BindAuto:
+		return "auto"
+	case BindDevice:
+		return "device"
+	case BindAddress:
+		return "address"
+	default:
+		return fmt.Sprintf("BindMode(%d)", int(m))
+	}
+}
+
+// ParseBindMode parses a BindMode ("auto", "device" or "address")
+func ParseBindMode(s string) (BindMode, error) {
+	for _, m := range []BindMode{BindAuto, BindDevice, BindAddress} {
+		if s == m.String() {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid bind mode: %s", s)
+}
+
+// InterfaceDialer dials connections bound to an interface.
+// Binding by address alone leaks the traffic to the default route on Linux when policy routing isn't set up,
+// SO_BINDTODEVICE forces the interface.
+type InterfaceDialer struct {
+	Dialer    net.Dialer // base dialer, its LocalAddr is ignored
+	Interface string
+	Mode      BindMode
+}
+
+// NewInterfaceDialer returns a dialer bound to the interface iface
+func NewInterfaceDialer(iface string, mode BindMode) (*InterfaceDialer, error) {
+	if _, err := net.InterfaceByName(iface); err != nil {
+		return nil, err
+	}
+	return &InterfaceDialer{Interface: iface, Mode: mode}, nil
+}
+
+// Dial connects to the address on the named network (tcp or udp), see net.Dial
+func (d *InterfaceDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects to the address on the named network (tcp or udp) using the provided context, see net.Dialer.DialContext
+func (d *InterfaceDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if d.Mode != BindAddress {
+		dialer := d.Dialer
+		dialer.LocalAddr = nil
+		dialer.Control = bindControl(d.Dialer.Control, d.Interface)
+		conn, err := dialer.DialContext(ctx, network, address)
+		if err == nil || d.Mode == BindDevice || !errors.Is(err, ErrBindToDevice) {
+			return conn, err
+		}
+	}
+	return d.dialAddress(ctx, network, address)
+}
+
+// dialAddress dials from the RFC 6724 source address of the interface for each destination address, in RFC 6724 order
+func (d *InterfaceDialer) dialAddress(ctx context.Context, network, address string) (net.Conn, error) {
+	host, service, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := net.DefaultResolver.LookupPort(ctx, network, service)
+	if err != nil {
+		return nil, err
+	}
+	var ips []netip.Addr
+	if ip, err := netip.ParseAddr(host); err == nil {
+		ips = []netip.Addr{ip}
+	} else {
+		if ips, err = net.DefaultResolver.LookupNetIP(ctx, ipNetwork(network), host); err != nil {
+			return nil, err
+		}
+	}
+	sources, err := GetSourceAddresses(d.Interface)
+	if err != nil {
+		return nil, err
+	}
+	SortDestinations(ips, sources)
+	var firstErr error
+	for _, ip := range ips {
+		ip = ip.Unmap()
+		source, ok := SelectSource(ip, sources)
+		if !ok {
+			continue
+		}
+		dialer := d.Dialer
+		local := net.IP(source.Addr().AsSlice())
+		zone := ""
+		if source.Addr().Is6() && source.Addr().IsLinkLocalUnicast() {
+			zone = d.Interface
+		}
+		if strings.HasPrefix(network, "udp") {
+			dialer.LocalAddr = &net.UDPAddr{IP: local, Zone: zone}
+		} else {
+			dialer.LocalAddr = &net.TCPAddr{IP: local, Zone: zone}
+		}
+		conn, err := dialer.DialContext(ctx, network, netip.AddrPortFrom(ip, uint16(port)).String())
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		firstErr = fmt.Errorf("no source address on %s for %s", d.Interface, host)
+	}
+	return nil, firstErr
+}
+
+// InterfaceListenConfig listens on sockets bound to an interface, see InterfaceDialer
+type InterfaceListenConfig struct {
+	ListenConfig net.ListenConfig // base configuration
+	Interface    string
+	Mode         BindMode
+}
+
+// NewInterfaceListenConfig returns a listen configuration bound to the interface iface
+func NewInterfaceListenConfig(iface string, mode BindMode) (*InterfaceListenConfig, error) {
+	if _, err := net.InterfaceByName(iface); err != nil {
+		return nil, err
+	}
+	return &InterfaceListenConfig{Interface: iface, Mode: mode}, nil
+}
+
+// Listen announces on the local network address (tcp), see net.ListenConfig.Listen.
+// When bound by address, an empty or unspecified host is replaced by the address of the interface.
+func (l *InterfaceListenConfig) Listen(ctx context.Context, network, address string) (net.Listener, error) {
+	if l.Mode != BindAddress {
+		lc := l.ListenConfig
+		lc.Control = bindControl(l.ListenConfig.Control, l.Interface)
+		ln, err := lc.Listen(ctx, network, address)
+		if err == nil || l.Mode == BindDevice || !errors.Is(err, ErrBindToDevice) {
+			return ln, err
+		}
+	}
+	address, err := l.interfaceAddress(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return l.ListenConfig.Listen(ctx, network, address)
+}
+
+// ListenPacket announces on the local network address (udp), see net.ListenConfig.ListenPacket
+func (l *InterfaceListenConfig) ListenPacket(ctx context.Context, network, address string) (net.PacketConn, error) {
+	if l.Mode != BindAddress {
+		lc := l.ListenConfig
+		lc.Control = bindControl(l.ListenConfig.Control, l.Interface)
+		pc, err := lc.ListenPacket(ctx, network, address)
+		if err == nil || l.Mode == BindDevice || !errors.Is(err, ErrBindToDevice) {
+			return pc, err
+		}
+	}
+	address, err := l.interfaceAddress(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return l.ListenConfig.ListenPacket(ctx, network, address)
+}
+
+// ListenQUIC returns a QUIC endpoint on the local network address (udp). Like quic.Listen,
+// the endpoint doesn't accept connections if config is nil but it can dial.
+func (l *InterfaceListenConfig) ListenQUIC(ctx context.Context, network, address string, config *quic.Config) (*quic.Endpoint, error) {
+	pc, err := l.ListenPacket(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	e, err := quic.NewEndpoint(pc, config)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+// interfaceAddress replaces an empty or unspecified host of address by the address of the interface
+func (l *InterfaceListenConfig) interfaceAddress(network, address string) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", err
+	}
+	version := networkIPVersion(network)
+	if host != "" {
+		a, err := netip.ParseAddr(host)
+		if err != nil || !a.IsUnspecified() {
+			return address, nil
+		}
+		version = GetIPVersion(a)
+	}
+	ipa, err := InterfaceAddress(l.Interface, version)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(ipa.String(), port), nil
+}
+
+// bindControl returns a socket control function binding to the interface iface after control (if not nil)
+func bindControl(control func(network, address string, c syscall.RawConn) error, iface string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		if control != nil {
+			if err := control(network, address, c); err != nil {
+				return err
+			}
+		}
+		return BindToDevice(c, iface)
+	}
+}
+
+// networkIPVersion returns the IP version of a network name ("tcp4" is 4, "udp6" is 6, "tcp" is 0)
+func networkIPVersion(network string) IPVersion {
+	switch {
+	case strings.HasSuffix(network, "4"):
+		return 4
+	case strings.HasSuffix(network, "6"):
+		return 6
+	default:
+		return 0
+	}
+}
+
+// ipNetwork returns the resolver network of a network name ("tcp4" is "ip4")
+func ipNetwork(network string) string {
+	return "ip" + networkIPVersion(network).NumericString()
+}
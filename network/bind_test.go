@@ -0,0 +1,102 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// loopback returns the name of the loopback interface
+func loopback(t *testing.T) string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, itf := range ifaces {
+		if itf.Flags&net.FlagLoopback != 0 && itf.Flags&net.FlagUp != 0 {
+			return itf.Name
+		}
+	}
+	t.Skip("no loopback interface")
+	return ""
+}
+
+func TestInterfaceDialer(t *testing.T) {
+	lo := loopback(t)
+	for _, mode := range []BindMode{BindAuto, BindDevice, BindAddress} {
+		t.Run(mode.String(), func(t *testing.T) {
+			lc, err := NewInterfaceListenConfig(lo, mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ln, err := lc.Listen(context.Background(), "tcp4", ":0")
+			if errors.Is(err, ErrBindToDevice) {
+				t.Skip(err)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+			go func() {
+				c, err := ln.Accept()
+				if err == nil {
+					c.Write([]byte("nspeed"))
+					c.Close()
+				}
+			}()
+			d, err := NewInterfaceDialer(lo, mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := d.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			b, err := io.ReadAll(c)
+			if err != nil || string(b) != "nspeed" {
+				t.Errorf("read %q, %v", b, err)
+			}
+			if ip := c.LocalAddr().(*net.TCPAddr).IP; !ip.IsLoopback() {
+				t.Errorf("local address %s is not on the loopback", ip)
+			}
+		})
+	}
+}
+
+func TestInterfaceListenQUIC(t *testing.T) {
+	lo := loopback(t)
+	lc, err := NewInterfaceListenConfig(lo, BindAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := lc.ListenQUIC(context.Background(), "udp4", ":0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.LocalAddr().Addr().IsLoopback() && !e.LocalAddr().Addr().IsUnspecified() {
+		t.Errorf("LocalAddr() = %s", e.LocalAddr())
+	}
+	e.Close(context.Background())
+}
+
+func TestBindMode(t *testing.T) {
+	for _, s := range []string{"auto", "device", "address"} {
+		m, err := ParseBindMode(s)
+		if err != nil || m.String() != s {
+			t.Errorf("ParseBindMode(%q) = %v, %v", s, m, err)
+		}
+	}
+	if _, err := ParseBindMode("foo"); err == nil {
+		t.Error("ParseBindMode(foo) should fail")
+	}
+	if _, err := NewInterfaceDialer("nspeed-none", BindAuto); err == nil {
+		t.Error("NewInterfaceDialer() of an unknown interface should fail")
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// BindToDevice binds a socket to the interface iface (SO_BINDTODEVICE),
+// the error wraps ErrBindToDevice.
+func BindToDevice(rc syscall.RawConn, iface string) error {
+	var err error
+	cerr := rc.Control(func(fd uintptr) {
+		err = unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, iface)
+	})
+	if cerr != nil {
+		return cerr
+	}
+	if errors.Is(err, unix.EPERM) {
+		return fmt.Errorf("%w %s: %w (CAP_NET_RAW or root is required)", ErrBindToDevice, iface, err)
+	}
+	if err != nil {
+		return fmt.Errorf("%w %s: %w", ErrBindToDevice, iface, err)
+	}
+	return nil
+}
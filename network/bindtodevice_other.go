@@ -0,0 +1,17 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !linux
+
+package network
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// BindToDevice is not supported on this platform, the error wraps ErrBindToDevice
+func BindToDevice(rc syscall.RawConn, iface string) error {
+	return fmt.Errorf("%w %s: not supported on this platform", ErrBindToDevice, iface)
+}
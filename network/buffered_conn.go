@@ -0,0 +1,34 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"bufio"
+	"net"
+)
+
+// bufferedConn is a net.Conn with a buffered reader
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// NewBufferedConn returns conn with a read buffer of size bytes (bufio default if size <= 0).
+// The wrapped connection is returned by its NetConn method like tls.Conn.
+func NewBufferedConn(conn net.Conn, size int) net.Conn {
+	if size <= 0 {
+		return &bufferedConn{Conn: conn, r: bufio.NewReader(conn)}
+	}
+	return &bufferedConn{Conn: conn, r: bufio.NewReaderSize(conn, size)}
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// NetConn returns the wrapped connection
+func (c *bufferedConn) NetConn() net.Conn {
+	return c.Conn
+}
@@ -0,0 +1,593 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNSTransport is the transport of the DNS queries
+type DNSTransport int
+
+const (
+	DNSUDP   DNSTransport = iota // plain UDP, retried over TCP if truncated
+	DNSTCP                       // plain TCP
+	DNSTLS                       // DNS over TLS (RFC 7858)
+	DNSHTTPS                     // DNS over HTTPS (RFC 8484)
+)
+
+func (t DNSTransport) String() string {
+	switch t {
+	case DNSUDP:
+		return "udp"
+	case DNSTCP:
+		return "tcp"
+	case DNSTLS:
+		return "tls"
+	case DNSHTTPS:
+		return "https"
+	default:
+		return fmt.Sprintf("DNSTransport(%d)", int(t))
+	}
+}
+
+// default ports
+var dnsPorts = map[DNSTransport]string{DNSUDP: "53", DNSTCP: "53", DNSTLS: "853"}
+
+// DNSServer is a DNS server and its transport
+type DNSServer struct {
+	Transport DNSTransport
+	Address   string // host:port, the URL for DNSHTTPS
+	Name      string // TLS server name, default to the host of Address
+}
+
+func (s DNSServer) String() string {
+	if s.Transport == DNSHTTPS {
+		return s.Address
+	}
+	return s.Transport.String() + "://" + s.Address
+}
+
+// ParseDNSServer parses a DNS server: a literal IP address or IP:port like SetDNSServer (UDP),
+// or an URL: udp://address[:port], tcp://address[:port], tls://host[:port] or https://host/path.
+// The default ports are 53 and 853 for tls.
+func ParseDNSServer(s string) (DNSServer, error) {
+	transport := DNSUDP
+	scheme, address, found := strings.Cut(s, "://")
+	if found {
+		switch scheme {
+		case "udp":
+		case "tcp":
+			transport = DNSTCP
+		case "tls":
+			transport = DNSTLS
+		case "https":
+			u, err := url.Parse(s)
+			if err != nil || u.Hostname() == "" {
+				return DNSServer{}, fmt.Errorf("invalid DNS server: %s", s)
+			}
+			if u.Path == "" {
+				u.Path = "/dns-query"
+			}
+			return DNSServer{Transport: DNSHTTPS, Address: u.String()}, nil
+		default:
+			return DNSServer{}, fmt.Errorf("invalid DNS server scheme: %s", scheme)
+		}
+	} else {
+		address = s
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host, port = strings.Trim(address, "[]"), dnsPorts[transport]
+	}
+	if host == "" {
+		return DNSServer{}, fmt.Errorf("invalid DNS server: %s", s)
+	}
+	if _, err := netip.ParseAddr(host); err != nil && transport != DNSTLS {
+		return DNSServer{}, fmt.Errorf("DNS server must be a literal IP address: %s", s)
+	}
+	return DNSServer{Transport: transport, Address: net.JoinHostPort(host, port)}, nil
+}
+
+// DNSResult is the answer of a DNS query and its metadata
+type DNSResult struct {
+	Server    DNSServer
+	Name      string
+	Type      dnsmessage.Type
+	Addresses []netip.Addr     // A or AAAA answers
+	CNAMEs    []string         // CNAME answers in order
+	TTL       time.Duration    // smallest TTL of the answers
+	RCode     dnsmessage.RCode // response code
+	Truncated bool             // the UDP answer was truncated, the query was retried over TCP
+	Start     time.Time
+	Connect   time.Duration // dial and TLS handshake, 0 when a connection is reused
+	RTT       time.Duration // from the query sent to the answer received
+	Size      int           // answer size in bytes
+}
+
+// DNSResolver queries a DNS server over UDP, TCP, TLS or HTTPS.
+// Unlike SetDNSServer it's not global: use it directly or with Resolver() for a dialer.
+type DNSResolver struct {
+	Server    DNSServer
+	Timeout   time.Duration // per query, 0 for 5 seconds
+	Dialer    net.Dialer
+	TLSConfig *tls.Config  // DNSTLS and DNSHTTPS, nil for the default
+	Client    *http.Client // DNSHTTPS, nil for a client using Dialer and TLSConfig
+
+	host  string       // host name of the server
+	addrs []netip.Addr // addresses of host, resolved by NewDNSResolver
+
+	once   sync.Once
+	client *http.Client
+}
+
+// NewDNSResolver returns a resolver for the server, see ParseDNSServer.
+// The host name of a tls:// or https:// server is resolved once, with the current net.DefaultResolver,
+// so the resolver can replace it (SetDNSServer) without resolving its own server.
+func NewDNSResolver(server string) (*DNSResolver, error) {
+	s, err := ParseDNSServer(server)
+	if err != nil {
+		return nil, err
+	}
+	r := &DNSResolver{Server: s}
+	host := s.host()
+	if _, err = netip.ParseAddr(host); err == nil {
+		return r, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if r.addrs, err = net.DefaultResolver.LookupNetIP(ctx, "ip", host); err != nil {
+		return nil, err
+	}
+	r.host = host
+	return r, nil
+}
+
+// host returns the host of the server address or URL
+func (s DNSServer) host() string {
+	if s.Transport == DNSHTTPS {
+		u, err := url.Parse(s.Address)
+		if err != nil {
+			return ""
+		}
+		return u.Hostname()
+	}
+	host, _, _ := net.SplitHostPort(s.Address)
+	return host
+}
+
+// dnsUDPSize is the EDNS(0) UDP payload size (DNS flag day 2020)
+const dnsUDPSize = 1232
+
+// Query sends a query of type qtype for name and returns the answer, the result is never nil.
+// A response code other than success isn't an error, it's in the result.
+func (r *DNSResolver) Query(ctx context.Context, name string, qtype dnsmessage.Type) (*DNSResult, error) {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	result := &DNSResult{Server: r.Server, Name: name, Type: qtype, Start: time.Now()}
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return result, err
+	}
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	id := uint16(rand.Uint32())
+	if r.Server.Transport == DNSHTTPS {
+		id = 0 // RFC 8484 section 4.1
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	b.EnableCompression()
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{Name: qname, Type: qtype, Class: dnsmessage.ClassINET})
+	b.StartAdditionals()
+	var opt dnsmessage.ResourceHeader
+	opt.SetEDNS0(dnsUDPSize, dnsmessage.RCodeSuccess, false)
+	b.OPTResource(opt, dnsmessage.OPTResource{})
+	query, err := b.Finish()
+	if err != nil {
+		return result, err
+	}
+	answer, err := r.exchange(ctx, query, result)
+	if err != nil {
+		return result, err
+	}
+	result.Size = len(answer)
+	return result, result.parse(answer, id)
+}
+
+// exchange sends the query to the server and returns the answer
+func (r *DNSResolver) exchange(ctx context.Context, query []byte, result *DNSResult) ([]byte, error) {
+	switch r.Server.Transport {
+	case DNSUDP:
+		answer, err := r.exchangeUDP(ctx, query, result)
+		if err == nil && len(answer) > 2 && answer[2]&0x02 != 0 { // TC bit
+			result.Truncated = true
+			return r.exchangeStream(ctx, query, result)
+		}
+		return answer, err
+	case DNSTCP, DNSTLS:
+		return r.exchangeStream(ctx, query, result)
+	case DNSHTTPS:
+		return r.exchangeHTTPS(ctx, query, result)
+	default:
+		return nil, fmt.Errorf("invalid DNS transport: %s", r.Server.Transport)
+	}
+}
+
+// parse fills the result with the answer to the query id
+func (result *DNSResult) parse(answer []byte, id uint16) error {
+	var p dnsmessage.Parser
+	h, err := p.Start(answer)
+	if err != nil {
+		return err
+	}
+	if h.ID != id || !h.Response {
+		return errors.New("invalid DNS answer")
+	}
+	result.RCode = h.RCode
+	if err = p.SkipAllQuestions(); err != nil {
+		return err
+	}
+	first := true
+	for {
+		rh, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if ttl := time.Duration(rh.TTL) * time.Second; first || ttl < result.TTL {
+			result.TTL, first = ttl, false
+		}
+		switch rh.Type {
+		case dnsmessage.TypeA:
+			a, err := p.AResource()
+			if err != nil {
+				return err
+			}
+			result.Addresses = append(result.Addresses, netip.AddrFrom4(a.A))
+		case dnsmessage.TypeAAAA:
+			a, err := p.AAAAResource()
+			if err != nil {
+				return err
+			}
+			result.Addresses = append(result.Addresses, netip.AddrFrom16(a.AAAA))
+		case dnsmessage.TypeCNAME:
+			c, err := p.CNAMEResource()
+			if err != nil {
+				return err
+			}
+			result.CNAMEs = append(result.CNAMEs, c.CNAME.String())
+		default:
+			if err = p.SkipAnswer(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// dial connects to the server, over TLS for DNSTLS
+func (r *DNSResolver) dial(ctx context.Context, network string, result *DNSResult) (net.Conn, error) {
+	start := time.Now()
+	defer func() { result.Connect = time.Since(start) }()
+	conn, err := r.dialContext(ctx, network, r.Server.Address)
+	if err != nil || r.Server.Transport != DNSTLS {
+		return conn, err
+	}
+	config := r.tlsConfig()
+	if config.ServerName == "" {
+		config.ServerName = r.Server.Name
+		if config.ServerName == "" {
+			config.ServerName = r.Server.host()
+		}
+	}
+	tconn := tls.Client(conn, config)
+	if err = tconn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tconn, nil
+}
+
+// dialContext connects to address with Dialer, the host name of the server is replaced by its resolved addresses
+func (r *DNSResolver) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || len(r.addrs) == 0 || host != r.host {
+		return r.Dialer.DialContext(ctx, network, address)
+	}
+	var conn net.Conn
+	for _, addr := range r.addrs {
+		if conn, err = r.Dialer.DialContext(ctx, network, net.JoinHostPort(addr.String(), port)); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+func (r *DNSResolver) tlsConfig() *tls.Config {
+	if r.TLSConfig == nil {
+		return &tls.Config{}
+	}
+	return r.TLSConfig.Clone()
+}
+
+func (r *DNSResolver) exchangeUDP(ctx context.Context, query []byte, result *DNSResult) ([]byte, error) {
+	conn, err := r.dial(ctx, "udp", result)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	start := time.Now()
+	if _, err = conn.Write(query); err != nil {
+		return nil, err
+	}
+	b := make([]byte, 65535)
+	for {
+		n, err := conn.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		// ignore the answers to other queries (spoofed or late)
+		if n >= 2 && b[0] == query[0] && b[1] == query[1] {
+			result.RTT = time.Since(start)
+			return b[:n], nil
+		}
+	}
+}
+
+// exchangeStream sends the query over TCP or TLS (RFC 1035 section 4.2.2 framing)
+func (r *DNSResolver) exchangeStream(ctx context.Context, query []byte, result *DNSResult) ([]byte, error) {
+	conn, err := r.dial(ctx, "tcp", result)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	start := time.Now()
+	if _, err = conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(query))), query...)); err != nil {
+		return nil, err
+	}
+	var l [2]byte
+	if _, err = io.ReadFull(conn, l[:]); err != nil {
+		return nil, err
+	}
+	b := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err = io.ReadFull(conn, b); err != nil {
+		return nil, err
+	}
+	result.RTT = time.Since(start)
+	return b, nil
+}
+
+// exchangeHTTPS POSTs the query, RFC 8484 section 4.1
+func (r *DNSResolver) exchangeHTTPS(ctx context.Context, query []byte, result *DNSResult) ([]byte, error) {
+	// the trace hooks may run on the goroutines of the transport (HTTP/2)
+	var mu sync.Mutex
+	start := time.Now()
+	var connect time.Duration
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			if !info.Reused {
+				connect = time.Since(start)
+			}
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			start = time.Now()
+		},
+	}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), http.MethodPost, r.Server.Address, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := r.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned: %s", r.Server.Address, resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 65535))
+	if err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	result.Connect, result.RTT = connect, time.Since(start)
+	return b, nil
+}
+
+func (r *DNSResolver) httpClient() *http.Client {
+	if r.Client != nil {
+		return r.Client
+	}
+	r.once.Do(func() {
+		r.client = &http.Client{Transport: &http.Transport{
+			DialContext:       r.dialContext,
+			TLSClientConfig:   r.tlsConfig(),
+			ForceAttemptHTTP2: true,
+		}}
+	})
+	return r.client
+}
+
+// Lookup returns the addresses of host for the network "ip", "ip4" or "ip6" and the results of the queries.
+// For "ip" the AAAA and A queries are concurrent.
+func (r *DNSResolver) Lookup(ctx context.Context, network, host string) ([]netip.Addr, []*DNSResult, error) {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{ip}, nil, nil
+	}
+	var qtypes []dnsmessage.Type
+	switch network {
+	case "ip":
+		qtypes = []dnsmessage.Type{dnsmessage.TypeAAAA, dnsmessage.TypeA}
+	case "ip4":
+		qtypes = []dnsmessage.Type{dnsmessage.TypeA}
+	case "ip6":
+		qtypes = []dnsmessage.Type{dnsmessage.TypeAAAA}
+	default:
+		return nil, nil, net.UnknownNetworkError(network)
+	}
+	results := make([]*DNSResult, len(qtypes))
+	errs := make([]error, len(qtypes))
+	var wg sync.WaitGroup
+	for i, qtype := range qtypes {
+		wg.Go(func() { results[i], errs[i] = r.Query(ctx, host, qtype) })
+	}
+	wg.Wait()
+
+	var addrs []netip.Addr
+	dnsErr := &net.DNSError{Name: host, Server: r.Server.String()}
+	for i, result := range results {
+		switch {
+		case errs[i] != nil:
+			dnsErr.Err = errs[i].Error()
+			var ne net.Error
+			dnsErr.IsTimeout = errors.As(errs[i], &ne) && ne.Timeout()
+		case result.RCode == dnsmessage.RCodeNameError:
+			dnsErr.Err, dnsErr.IsNotFound = "no such host", true
+		case result.RCode != dnsmessage.RCodeSuccess:
+			dnsErr.Err = "server misbehaving: " + result.RCode.String()
+			dnsErr.IsTemporary = result.RCode == dnsmessage.RCodeServerFailure
+		default:
+			addrs = append(addrs, result.Addresses...)
+		}
+	}
+	if len(addrs) == 0 {
+		if dnsErr.Err == "" {
+			dnsErr.Err, dnsErr.IsNotFound = "no such host", true
+		}
+		return nil, results, dnsErr
+	}
+	return addrs, results, nil
+}
+
+// Resolver returns a net.Resolver querying the server, for net.Dialer or HappyEyeballsDialer
+func (r *DNSResolver) Resolver() *net.Resolver {
+	return &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+		return &dnsConn{r: r, ctx: ctx}, nil
+	}}
+}
+
+// dnsConn is a net.Conn for net.Resolver: the framed queries written are exchanged with the server of r.
+// It's not a net.PacketConn so net.Resolver frames the messages like TCP.
+type dnsConn struct {
+	r        *DNSResolver
+	ctx      context.Context
+	deadline time.Time
+	query    bytes.Buffer
+	answer   bytes.Buffer
+	closed   bool
+}
+
+func (c *dnsConn) Write(b []byte) (int, error) {
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	c.query.Write(b)
+	return len(b), nil
+}
+
+func (c *dnsConn) Read(b []byte) (int, error) {
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	if c.answer.Len() == 0 && c.query.Len() >= 2 {
+		l := int(binary.BigEndian.Uint16(c.query.Bytes()))
+		if c.query.Len() < 2+l {
+			return 0, io.ErrUnexpectedEOF
+		}
+		c.query.Next(2)
+		query := c.query.Next(l)
+		if err := c.exchange(query); err != nil {
+			return 0, err
+		}
+	}
+	if c.answer.Len() == 0 {
+		return 0, io.EOF
+	}
+	return c.answer.Read(b)
+}
+
+// exchange sends a query built by net.Resolver to the server
+func (c *dnsConn) exchange(query []byte) error {
+	ctx := c.ctx
+	if !c.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+	id := binary.BigEndian.Uint16(query)
+	if c.r.Server.Transport == DNSHTTPS {
+		query = bytes.Clone(query)
+		query[0], query[1] = 0, 0 // RFC 8484 section 4.1, restored in the answer
+	}
+	answer, err := c.r.exchange(ctx, query, &DNSResult{})
+	if err != nil {
+		return err
+	}
+	if len(answer) >= 2 {
+		binary.BigEndian.PutUint16(answer, id)
+	}
+	c.answer.Write(binary.BigEndian.AppendUint16(nil, uint16(len(answer))))
+	c.answer.Write(answer)
+	return nil
+}
+
+func (c *dnsConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func (c *dnsConn) LocalAddr() net.Addr  { return dnsAddr("") }
+func (c *dnsConn) RemoteAddr() net.Addr { return dnsAddr(c.r.Server.String()) }
+
+func (c *dnsConn) SetDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+func (c *dnsConn) SetReadDeadline(t time.Time) error  { return c.SetDeadline(t) }
+func (c *dnsConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type dnsAddr string
+
+func (a dnsAddr) Network() string { return "dns" }
+func (a dnsAddr) String() string  { return string(a) }
@@ -0,0 +1,306 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestParseDNSServer(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    string
+		wantErr bool
+	}{
+		{"1.1.1.1", "udp://1.1.1.1:53", false},
+		{"1.1.1.1:5353", "udp://1.1.1.1:5353", false},
+		{"::1", "udp://[::1]:53", false},
+		{"[::1]:53", "udp://[::1]:53", false},
+		{"tcp://9.9.9.9", "tcp://9.9.9.9:53", false},
+		{"tls://dns.google", "tls://dns.google:853", false},
+		{"tls://1.1.1.1:8853", "tls://1.1.1.1:8853", false},
+		{"https://dns.google", "https://dns.google/dns-query", false},
+		{"https://cloudflare-dns.com/dns-query", "https://cloudflare-dns.com/dns-query", false},
+		{"https://dns.google:8443", "https://dns.google:8443/dns-query", false},
+		{"dns.google", "", true},
+		{"quic://1.1.1.1", "", true},
+		{"https://", "", true},
+		{"https://:443/dns-query", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := ParseDNSServer(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDNSServer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.String() != tt.want {
+				t.Errorf("ParseDNSServer() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// dnsAnswer answers the test zone:
+// a.nspeed.test has an A and an AAAA record, cname.nspeed.test is a CNAME of a.nspeed.test,
+// big.nspeed.test has 100 A records (truncated over UDP), dns.example.com (the name of the test certificate)
+// is 127.0.0.1, the other names don't exist.
+func dnsAnswer(query []byte, udp bool) []byte {
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	if err != nil {
+		return nil
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil
+	}
+	rh := dnsmessage.Header{ID: h.ID, Response: true, RecursionDesired: h.RecursionDesired, RecursionAvailable: true}
+	a := dnsmessage.MustNewName("a.nspeed.test.")
+	var answers []dnsmessage.Resource
+	switch q.Name.String() {
+	case "cname.nspeed.test.":
+		answers = append(answers, dnsmessage.Resource{Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeCNAME, Class: dnsmessage.ClassINET, TTL: 600},
+			Body: &dnsmessage.CNAMEResource{CNAME: a}})
+		fallthrough
+	case "a.nspeed.test.":
+		if q.Type == dnsmessage.TypeA {
+			answers = append(answers, dnsmessage.Resource{Header: dnsmessage.ResourceHeader{Name: a, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 300},
+				Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}})
+		}
+		if q.Type == dnsmessage.TypeAAAA {
+			answers = append(answers, dnsmessage.Resource{Header: dnsmessage.ResourceHeader{Name: a, Type: dnsmessage.TypeAAAA, Class: dnsmessage.ClassINET, TTL: 300},
+				Body: &dnsmessage.AAAAResource{AAAA: netip.MustParseAddr("2001:db8::1").As16()}})
+		}
+	case "big.nspeed.test.":
+		if udp {
+			rh.Truncated = true
+			break
+		}
+		for i := range 100 {
+			answers = append(answers, dnsmessage.Resource{Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+				Body: &dnsmessage.AResource{A: [4]byte{198, 51, 100, byte(i)}}})
+		}
+	case "dns.example.com.":
+		if q.Type == dnsmessage.TypeA {
+			answers = append(answers, dnsmessage.Resource{Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+				Body: &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}}})
+		}
+	default:
+		rh.RCode = dnsmessage.RCodeNameError
+	}
+	m := dnsmessage.Message{Header: rh, Questions: []dnsmessage.Question{q}, Answers: answers}
+	b, _ := m.Pack()
+	return b
+}
+
+// serveDNSStream answers the queries of a TCP or TLS connection
+func serveDNSStream(c net.Conn) {
+	defer c.Close()
+	for {
+		var l [2]byte
+		if _, err := io.ReadFull(c, l[:]); err != nil {
+			return
+		}
+		b := make([]byte, binary.BigEndian.Uint16(l[:]))
+		if _, err := io.ReadFull(c, b); err != nil {
+			return
+		}
+		answer := dnsAnswer(b, false)
+		if _, err := c.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(answer))), answer...)); err != nil {
+			return
+		}
+	}
+}
+
+// testDNSServers starts the test zone servers and returns them with the TLS configuration of the clients
+func testDNSServers(t *testing.T) (servers []DNSServer, config *tls.Config) {
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	// the TCP server listens on the same port than UDP for the truncated answers
+	ln, err := net.Listen("tcp4", pc.LocalAddr().String())
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		b := make([]byte, 65535)
+		for {
+			n, addr, err := pc.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(dnsAnswer(b[:n], true), addr)
+		}
+	}()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveDNSStream(c)
+		}
+	}()
+
+	doh := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil || r.Header.Get("Content-Type") != "application/dns-message" || len(b) < 2 || b[0] != 0 || b[1] != 0 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(dnsAnswer(b, false))
+	}))
+	doh.EnableHTTP2 = true
+	doh.StartTLS()
+	t.Cleanup(doh.Close)
+	config = doh.Client().Transport.(*http.Transport).TLSClientConfig
+
+	dot, err := tls.Listen("tcp4", "127.0.0.1:0", doh.TLS)
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { dot.Close() })
+	go func() {
+		for {
+			c, err := dot.Accept()
+			if err != nil {
+				return
+			}
+			go serveDNSStream(c)
+		}
+	}()
+
+	return []DNSServer{
+		{Transport: DNSUDP, Address: pc.LocalAddr().String()},
+		{Transport: DNSTCP, Address: ln.Addr().String()},
+		{Transport: DNSTLS, Address: dot.Addr().String()},
+		{Transport: DNSHTTPS, Address: doh.URL + "/dns-query"},
+	}, config
+}
+
+func TestDNSResolver(t *testing.T) {
+	servers, config := testDNSServers(t)
+	ctx := context.Background()
+	for _, server := range servers {
+		t.Run(server.Transport.String(), func(t *testing.T) {
+			r := &DNSResolver{Server: server, TLSConfig: config, Timeout: 2 * time.Second}
+
+			result, err := r.Query(ctx, "cname.nspeed.test", dnsmessage.TypeA)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.RCode != dnsmessage.RCodeSuccess || !slices.Equal(result.Addresses, []netip.Addr{netip.MustParseAddr("192.0.2.1")}) ||
+				!slices.Equal(result.CNAMEs, []string{"a.nspeed.test."}) || result.TTL != 300*time.Second || result.Truncated {
+				t.Errorf("Query() = %+v", result)
+			}
+			if result.RTT <= 0 || result.Size == 0 || result.Server != server {
+				t.Errorf("Query() metadata = %+v", result)
+			}
+
+			result, err = r.Query(ctx, "none.nspeed.test", dnsmessage.TypeAAAA)
+			if err != nil || result.RCode != dnsmessage.RCodeNameError || len(result.Addresses) != 0 {
+				t.Errorf("Query() of a missing name = %+v, %v", result, err)
+			}
+
+			result, err = r.Query(ctx, "big.nspeed.test", dnsmessage.TypeA)
+			if err != nil || len(result.Addresses) != 100 || result.Truncated != (server.Transport == DNSUDP) {
+				t.Errorf("Query() of a big answer = %d addresses, truncated %v, %v", len(result.Addresses), result.Truncated, err)
+			}
+
+			addrs, results, err := r.Lookup(ctx, "ip", "a.nspeed.test")
+			if err != nil || len(addrs) != 2 || len(results) != 2 || results[0].Type != dnsmessage.TypeAAAA {
+				t.Errorf("Lookup() = %v, %v, %v", addrs, results, err)
+			}
+			if _, _, err = r.Lookup(ctx, "ip4", "none.nspeed.test"); err == nil {
+				t.Error("Lookup() of a missing name should fail")
+			} else if dnsErr, ok := err.(*net.DNSError); !ok || !dnsErr.IsNotFound {
+				t.Errorf("Lookup() error = %#v, want not found", err)
+			}
+
+			// with the standard library resolver
+			ips, err := r.Resolver().LookupNetIP(ctx, "ip", "cname.nspeed.test")
+			slices.SortFunc(ips, netip.Addr.Compare)
+			if err != nil || !slices.Equal(ips, []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("2001:db8::1")}) {
+				t.Errorf("Resolver().LookupNetIP() = %v, %v", ips, err)
+			}
+			if _, err = r.Resolver().LookupNetIP(ctx, "ip", "none.nspeed.test"); err == nil {
+				t.Error("Resolver().LookupNetIP() of a missing name should fail")
+			}
+		})
+	}
+}
+
+func TestDNSResolverTimeout(t *testing.T) {
+	// a server which never answers
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer pc.Close()
+	r := &DNSResolver{Server: DNSServer{Transport: DNSUDP, Address: pc.LocalAddr().String()}, Timeout: 50 * time.Millisecond}
+	_, _, err = r.Lookup(context.Background(), "ip4", "a.nspeed.test")
+	if dnsErr, ok := err.(*net.DNSError); !ok || !dnsErr.IsTimeout {
+		t.Errorf("Lookup() error = %#v, want a timeout", err)
+	}
+}
+
+func TestSetDNSServer(t *testing.T) {
+	servers, config := testDNSServers(t)
+	prefer, dial := net.DefaultResolver.PreferGo, net.DefaultResolver.Dial
+	t.Cleanup(func() { net.DefaultResolver.PreferGo, net.DefaultResolver.Dial = prefer, dial })
+
+	_, port, _ := net.SplitHostPort(servers[2].Address)
+	for _, server := range []string{"tls://dns.example.com:" + port, strings.Replace(servers[3].Address, "127.0.0.1", "dns.example.com", 1)} {
+		t.Run(server, func(t *testing.T) {
+			// dns.example.com is resolved with the previous resolver
+			if err := SetDNSServer(servers[0].Address); err != nil {
+				t.Fatal(err)
+			}
+			r, err := NewDNSResolver(server)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(r.addrs, []netip.Addr{netip.MustParseAddr("127.0.0.1")}) {
+				t.Errorf("NewDNSResolver() addresses = %v", r.addrs)
+			}
+
+			// the server is dialed by address with its name for TLS
+			r.TLSConfig, r.Timeout = config, 2*time.Second
+			net.DefaultResolver.Dial = r.Resolver().Dial
+			ips, err := net.DefaultResolver.LookupNetIP(context.Background(), "ip4", "a.nspeed.test")
+			if err != nil || !slices.Equal(ips, []netip.Addr{netip.MustParseAddr("192.0.2.1")}) {
+				t.Errorf("LookupNetIP() = %v, %v", ips, err)
+			}
+
+			// the test certificate isn't trusted by SetDNSServer but the server is reached without resolving its own name
+			if err = SetDNSServer(server); err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			_, err = net.DefaultResolver.LookupNetIP(ctx, "ip4", "a.nspeed.test")
+			if dnsErr, ok := err.(*net.DNSError); err == nil || (ok && dnsErr.IsTimeout) {
+				t.Errorf("LookupNetIP() error = %#v, want a certificate error", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"math"
+	"slices"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNSBenchOptions are the options of DNSBench
+type DNSBenchOptions struct {
+	Count     int               // number of rounds, 0 for 1
+	Interval  time.Duration     // delay between the rounds
+	Types     []dnsmessage.Type // query types, nil for A and AAAA
+	Timeout   time.Duration     // per query, 0 for the DNSResolver default
+	TLSConfig *tls.Config       // DoT and DoH servers, nil for the default
+}
+
+// DNSBenchQuery is a query of DNSBench
+type DNSBenchQuery struct {
+	Round  int // from 0, the names are usually not in the cache of the server in the first round
+	Result *DNSResult
+	Err    error
+}
+
+// DNSBenchStats are the statistics of the queries of a type to a server
+type DNSBenchStats struct {
+	Server    DNSServer
+	Type      dnsmessage.Type
+	Queries   int
+	Failures  int           // errors (timeout ...) and answers other than success and NXDOMAIN (SERVFAIL, REFUSED ...)
+	NotFound  int           // NXDOMAIN answers
+	NoData    int           // success answers without address (a name without AAAA record for instance)
+	Answered  int           // names with an address
+	CacheHits int           // answers of the later rounds with a TTL lower than the first one (served from the cache)
+	Min       time.Duration // RTT of the answers
+	Avg       time.Duration
+	Max       time.Duration
+	P50       time.Duration
+	P90       time.Duration
+	First     time.Duration // average RTT of the first round (uncached)
+	Repeated  time.Duration // average RTT of the later rounds (cached)
+	Connect   time.Duration // average connection time (dial and TLS handshake)
+}
+
+// FailureRate returns the percentage of failed queries
+func (s DNSBenchStats) FailureRate() float64 {
+	if s.Queries == 0 {
+		return 0
+	}
+	return 100 * float64(s.Failures) / float64(s.Queries)
+}
+
+// dnsBenchAcc accumulates the queries of a DNSBenchStats
+type dnsBenchAcc struct {
+	rtts            []time.Duration
+	first, repeated []time.Duration
+	connect         time.Duration
+	ttls            map[string]time.Duration // TTL of the first answer of the names
+	answered        map[string]bool
+}
+
+// DNSBench resolves names against servers (see ParseDNSServer) options.Count times and returns the statistics
+// per server and query type, in order. The queries are sequential: for each round, for each name, for each server.
+// report, if not nil, is called after each query.
+func DNSBench(ctx context.Context, servers, names []string, options DNSBenchOptions, report func(DNSBenchQuery)) ([]DNSBenchStats, error) {
+	resolvers := make([]*DNSResolver, 0, len(servers))
+	for _, s := range servers {
+		r, err := NewDNSResolver(s)
+		if err != nil {
+			return nil, err
+		}
+		r.Timeout, r.TLSConfig = options.Timeout, options.TLSConfig
+		resolvers = append(resolvers, r)
+	}
+	types := options.Types
+	if len(types) == 0 {
+		types = []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}
+	}
+	count := max(options.Count, 1)
+
+	stats := make([]DNSBenchStats, len(resolvers)*len(types))
+	accs := make([]dnsBenchAcc, len(stats))
+	for i, r := range resolvers {
+		for j, t := range types {
+			stats[i*len(types)+j] = DNSBenchStats{Server: r.Server, Type: t}
+			accs[i*len(types)+j] = dnsBenchAcc{ttls: make(map[string]time.Duration), answered: make(map[string]bool)}
+		}
+	}
+
+	var err error
+rounds:
+	for round := range count {
+		if round > 0 && options.Interval > 0 {
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+				break rounds
+			case <-time.After(options.Interval):
+			}
+		}
+		for _, name := range names {
+			for i, r := range resolvers {
+				for j, t := range types {
+					if err = ctx.Err(); err != nil {
+						break rounds
+					}
+					result, qerr := r.Query(ctx, name, t)
+					if report != nil {
+						report(DNSBenchQuery{Round: round, Result: result, Err: qerr})
+					}
+					k := i*len(types) + j
+					stats[k].add(&accs[k], round, name, result, qerr)
+				}
+			}
+		}
+	}
+	for k := range stats {
+		stats[k].compute(&accs[k])
+	}
+	return stats, err
+}
+
+// add records a query of the round
+func (s *DNSBenchStats) add(acc *dnsBenchAcc, round int, name string, result *DNSResult, err error) {
+	s.Queries++
+	if err != nil {
+		s.Failures++
+		return
+	}
+	switch result.RCode {
+	case dnsmessage.RCodeSuccess:
+		if len(result.Addresses) == 0 {
+			s.NoData++
+		} else {
+			acc.answered[name] = true
+		}
+	case dnsmessage.RCodeNameError:
+		s.NotFound++
+	default:
+		s.Failures++
+		return
+	}
+	acc.rtts = append(acc.rtts, result.RTT)
+	acc.connect += result.Connect
+	if round == 0 {
+		acc.first = append(acc.first, result.RTT)
+		acc.ttls[name] = result.TTL
+	} else {
+		acc.repeated = append(acc.repeated, result.RTT)
+		if ttl, ok := acc.ttls[name]; ok && len(result.Addresses) > 0 && result.TTL < ttl {
+			s.CacheHits++
+		}
+	}
+}
+
+// compute sets the RTT statistics
+func (s *DNSBenchStats) compute(acc *dnsBenchAcc) {
+	s.Answered = len(acc.answered)
+	n := len(acc.rtts)
+	if n == 0 {
+		return
+	}
+	slices.Sort(acc.rtts)
+	s.Min, s.Max = acc.rtts[0], acc.rtts[n-1]
+	s.Avg = average(acc.rtts)
+	s.P50, s.P90 = percentile(acc.rtts, 50), percentile(acc.rtts, 90)
+	s.First, s.Repeated = average(acc.first), average(acc.repeated)
+	s.Connect = acc.connect / time.Duration(n)
+}
+
+func average(d []time.Duration) time.Duration {
+	if len(d) == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for _, v := range d {
+		sum += v
+	}
+	return sum / time.Duration(len(d))
+}
+
+// percentile returns the pth percentile (0 < p <= 100) of sorted with the nearest-rank method
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	n := len(sorted)
+	if n == 0 || p <= 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(n)))
+	return sorted[min(max(rank, 1), n)-1]
+}
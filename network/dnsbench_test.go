@@ -0,0 +1,77 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestDNSBench(t *testing.T) {
+	servers, config := testDNSServers(t)
+	var addresses []string
+	for _, s := range servers {
+		addresses = append(addresses, s.String())
+	}
+	// a server which never answers
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer pc.Close()
+	addresses = append(addresses, pc.LocalAddr().String())
+
+	queries := 0
+	options := DNSBenchOptions{Count: 2, Timeout: 100 * time.Millisecond, TLSConfig: config}
+	stats, err := DNSBench(context.Background(), addresses, []string{"a.nspeed.test", "none.nspeed.test"}, options,
+		func(DNSBenchQuery) { queries++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2*len(addresses) || queries != 2*2*2*len(addresses) {
+		t.Fatalf("DNSBench() = %d stats, %d queries", len(stats), queries)
+	}
+	for i, s := range stats {
+		wantType := dnsmessage.TypeA
+		if i%2 == 1 {
+			wantType = dnsmessage.TypeAAAA
+		}
+		if s.Type != wantType || s.Queries != 4 {
+			t.Errorf("%s %s: %d queries", s.Server, s.Type, s.Queries)
+		}
+		if i/2 == len(servers) {
+			if s.Failures != 4 || s.FailureRate() != 100 || s.Answered != 0 {
+				t.Errorf("%s %s: %+v, want failures", s.Server, s.Type, s)
+			}
+			continue
+		}
+		if s.Failures != 0 || s.NotFound != 2 || s.Answered != 1 || s.CacheHits != 0 {
+			t.Errorf("%s %s: %+v", s.Server, s.Type, s)
+		}
+		if s.Min <= 0 || s.Min > s.P50 || s.P50 > s.P90 || s.P90 > s.Max || s.First <= 0 || s.Repeated <= 0 {
+			t.Errorf("%s %s: RTT %+v", s.Server, s.Type, s)
+		}
+	}
+
+	if _, err = DNSBench(context.Background(), []string{"dns.google"}, []string{"a.nspeed.test"}, options, nil); err == nil {
+		t.Error("DNSBench() with an invalid server should fail")
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	d := []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	for _, tt := range []struct {
+		p    float64
+		want time.Duration
+	}{{50, 5}, {90, 9}, {99, 10}, {100, 10}, {1, 1}, {0, 0}} {
+		if got := percentile(d, tt.p); got != tt.want {
+			t.Errorf("percentile(%v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// RFC 8305 recommended delays
+const (
+	DefaultResolutionDelay        = 50 * time.Millisecond  // time to wait for the AAAA answer after the A one
+	DefaultConnectionAttemptDelay = 250 * time.Millisecond // time before starting the next connection attempt
+	MinConnectionAttemptDelay     = 10 * time.Millisecond
+)
+
+// HappyEyeballsDialer dials with RFC 8305 Happy Eyeballs v2: AAAA and A queries are raced,
+// the addresses are sorted (RFC 6724) and interleaved by family and the connection attempts are staggered.
+// Unlike net.Dialer, it returns the trace of every attempt.
+type HappyEyeballsDialer struct {
+	Dialer                  net.Dialer    // dialer of each attempt
+	Resolver                *net.Resolver // nil for net.DefaultResolver
+	ResolutionDelay         time.Duration // 0 for DefaultResolutionDelay
+	ConnectionAttemptDelay  time.Duration // 0 for DefaultConnectionAttemptDelay, at least MinConnectionAttemptDelay
+	FirstAddressFamilyCount int           // number of addresses of the preferred family tried first, 0 for 1
+}
+
+// ResolutionTrace is a DNS query of a DialTrace
+type ResolutionTrace struct {
+	Version   IPVersion     // 6 for AAAA, 4 for A
+	Addresses []netip.Addr  // answer
+	Elapsed   time.Duration // since the start of the dial
+	Err       error
+}
+
+// DialAttempt is a connection attempt of a DialTrace
+type DialAttempt struct {
+	Address  netip.AddrPort
+	Start    time.Duration // since the start of the dial
+	Duration time.Duration
+	Err      error // nil for the winner, context.Canceled for the attempts canceled by the winner
+}
+
+// DialTrace is the trace of a HappyEyeballsDialer dial
+type DialTrace struct {
+	Host        string
+	Start       time.Time
+	Resolutions []ResolutionTrace // in order of answer, none for a literal address
+	Attempts    []DialAttempt     // in order of start
+	Winner      int               // index of the connected attempt, -1 if none
+	Duration    time.Duration
+}
+
+// Version returns the IP version of the connected attempt, 0 if none
+func (t *DialTrace) Version() IPVersion {
+	if t.Winner < 0 {
+		return 0
+	}
+	return GetIPVersion(t.Attempts[t.Winner].Address.Addr())
+}
+
+func (t *DialTrace) String() string {
+	var b strings.Builder
+	for _, r := range t.Resolutions {
+		query := "A"
+		if r.Version == 6 {
+			query = "AAAA"
+		}
+		if r.Err != nil {
+			fmt.Fprintf(&b, "resolve %s %s: %v after %s\n", query, t.Host, r.Err, r.Elapsed)
+		} else {
+			fmt.Fprintf(&b, "resolve %s %s: %v after %s\n", query, t.Host, r.Addresses, r.Elapsed)
+		}
+	}
+	for i, a := range t.Attempts {
+		fmt.Fprintf(&b, "attempt %d %s at %s: ", i+1, a.Address, a.Start)
+		switch {
+		case i == t.Winner:
+			fmt.Fprintf(&b, "connected in %s\n", a.Duration)
+		case errors.Is(a.Err, context.Canceled):
+			fmt.Fprintf(&b, "canceled after %s\n", a.Duration)
+		default:
+			fmt.Fprintf(&b, "failed after %s: %v\n", a.Duration, a.Err)
+		}
+	}
+	if t.Winner >= 0 {
+		fmt.Fprintf(&b, "connected with %s in %s", t.Version(), t.Duration)
+	} else {
+		fmt.Fprintf(&b, "failed in %s", t.Duration)
+	}
+	return b.String()
+}
+
+// Dial connects to the address on the named network (tcp, tcp4, tcp6, udp ...), see net.Dial
+func (d *HappyEyeballsDialer) Dial(network, address string) (net.Conn, *DialTrace, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+type answer struct {
+	version IPVersion
+	addrs   []netip.Addr
+	err     error
+	at      time.Duration
+}
+
+type attemptResult struct {
+	index int
+	conn  net.Conn
+	err   error
+	at    time.Duration
+}
+
+// DialContext connects to the address on the named network using the provided context.
+// The trace is returned even on error.
+func (d *HappyEyeballsDialer) DialContext(ctx context.Context, network, address string) (net.Conn, *DialTrace, error) {
+	trace := &DialTrace{Start: time.Now(), Winner: -1}
+	since := func() time.Duration { return time.Since(trace.Start) }
+	defer func() { trace.Duration = since() }()
+
+	host, service, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, trace, err
+	}
+	trace.Host = host
+	resolver := d.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	port, err := resolver.LookupPort(ctx, network, service)
+	if err != nil {
+		return nil, trace, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// resolution: AAAA and A are raced, none for a literal address
+	var addrs [2][]netip.Addr // IPv6, IPv4
+	answers := make(chan answer, 2)
+	pending := 0
+	proceed := false // the connection attempts can start
+	if ip, err := netip.ParseAddr(host); err == nil {
+		ip = ip.Unmap()
+		if ip.Is4() {
+			addrs[1] = []netip.Addr{ip}
+		} else {
+			addrs[0] = []netip.Addr{ip}
+		}
+		proceed = true
+	} else {
+		version := networkIPVersion(network)
+		for _, v := range []IPVersion{6, 4} {
+			if version != 0 && version != v {
+				continue
+			}
+			pending++
+			go func() {
+				a, err := resolver.LookupNetIP(ctx, "ip"+v.NumericString(), host)
+				answers <- answer{version: v, addrs: a, err: err, at: since()}
+			}()
+		}
+	}
+	resolutionDelay := d.ResolutionDelay
+	if resolutionDelay <= 0 {
+		resolutionDelay = DefaultResolutionDelay
+	}
+	attemptDelay := d.ConnectionAttemptDelay
+	if attemptDelay <= 0 {
+		attemptDelay = DefaultConnectionAttemptDelay
+	}
+	attemptDelay = max(attemptDelay, MinConnectionAttemptDelay)
+	firstCount := max(d.FirstAddressFamilyCount, 1)
+	sources, _ := GetSourceAddresses("")
+
+	resolutionTimer := time.NewTimer(0)
+	resolutionTimer.Stop()
+	attemptTimer := time.NewTimer(0)
+	attemptTimer.Stop()
+	defer resolutionTimer.Stop()
+	defer attemptTimer.Stop()
+
+	tried := make(map[netip.Addr]bool)
+	results := make(chan attemptResult)
+	inflight := 0
+	armed := false                // attemptTimer runs: the attempt delay of the last attempt isn't over
+	var resolveErr, dialErr error // first errors
+
+	// next starts the next connection attempt, false if there's no address left
+	next := func() bool {
+		all := append(append([]netip.Addr{}, addrs[0]...), addrs[1]...)
+		if len(sources) > 0 {
+			SortDestinations(all, sources)
+		}
+		for _, ip := range interleave(all, firstCount) {
+			if tried[ip] {
+				continue
+			}
+			tried[ip] = true
+			i := len(trace.Attempts)
+			ap := netip.AddrPortFrom(ip, uint16(port))
+			trace.Attempts = append(trace.Attempts, DialAttempt{Address: ap, Start: since()})
+			inflight++
+			go func() {
+				conn, err := d.Dialer.DialContext(ctx, network, ap.String())
+				results <- attemptResult{index: i, conn: conn, err: err, at: since()}
+			}()
+			attemptTimer.Reset(attemptDelay)
+			armed = true
+			return true
+		}
+		return false
+	}
+	// wait for the in flight attempts canceled by the winner or the context
+	drain := func() {
+		cancel()
+		for ; inflight > 0; inflight-- {
+			r := <-results
+			if r.conn != nil {
+				r.conn.Close()
+			}
+			a := &trace.Attempts[r.index]
+			a.Duration, a.Err = r.at-a.Start, context.Canceled
+		}
+	}
+
+	if proceed {
+		next()
+	}
+	for {
+		if proceed && inflight == 0 && pending == 0 && !next() {
+			switch {
+			case dialErr != nil:
+				return nil, trace, dialErr
+			case resolveErr != nil:
+				return nil, trace, resolveErr
+			default:
+				return nil, trace, fmt.Errorf("no address found for %s", host)
+			}
+		}
+		select {
+		case a := <-answers:
+			pending--
+			trace.Resolutions = append(trace.Resolutions, ResolutionTrace{Version: a.version, Addresses: a.addrs, Elapsed: a.at, Err: a.err})
+			if a.err != nil {
+				if resolveErr == nil {
+					resolveErr = a.err
+				}
+			} else if a.version == 6 {
+				addrs[0] = a.addrs
+			} else {
+				addrs[1] = a.addrs
+			}
+			switch {
+			case proceed:
+				// RFC 8305 section 5: the new addresses join the race when the attempt delay
+				// of the last attempt is over, at once if it's already over
+				if inflight == 0 || !armed {
+					next()
+				}
+			case a.version == 6 && len(a.addrs) > 0, pending == 0:
+				proceed = true
+				next()
+			case a.version == 4 && len(a.addrs) > 0:
+				// RFC 8305 section 3: wait a little for the AAAA answer
+				resolutionTimer.Reset(resolutionDelay)
+			}
+		case <-resolutionTimer.C:
+			if !proceed {
+				proceed = true
+				next()
+			}
+		case <-attemptTimer.C:
+			armed = false
+			next()
+		case r := <-results:
+			inflight--
+			a := &trace.Attempts[r.index]
+			a.Duration, a.Err = r.at-a.Start, r.err
+			if r.err == nil {
+				trace.Winner = r.index
+				drain()
+				return r.conn, trace, nil
+			}
+			if dialErr == nil {
+				dialErr = r.err
+			}
+			// RFC 8305 section 5: a failure starts the next attempt at once
+			next()
+		case <-ctx.Done():
+			drain()
+			return nil, trace, ctx.Err()
+		}
+	}
+}
+
+// interleave orders addresses by alternating the IP families, starting with firstCount
+// addresses of the family of the first address (RFC 8305 section 4)
+func interleave(addrs []netip.Addr, firstCount int) []netip.Addr {
+	if len(addrs) == 0 {
+		return nil
+	}
+	var first, other []netip.Addr
+	for _, a := range addrs {
+		if a.Unmap().Is4() == addrs[0].Unmap().Is4() {
+			first = append(first, a)
+		} else {
+			other = append(other, a)
+		}
+	}
+	n := min(firstCount, len(first))
+	r := append(make([]netip.Addr, 0, len(addrs)), first[:n]...)
+	first = first[n:]
+	for len(first) > 0 || len(other) > 0 {
+		if len(other) > 0 {
+			r = append(r, other[0])
+			other = other[1:]
+		}
+		if len(first) > 0 {
+			r = append(r, first[0])
+			first = first[1:]
+		}
+	}
+	return r
+}
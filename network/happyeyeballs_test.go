@@ -0,0 +1,233 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestInterleave(t *testing.T) {
+	tests := []struct {
+		name       string
+		addrs      string
+		firstCount int
+		want       string
+	}{
+		{"empty", "", 1, ""},
+		{"alternate", "2001:db8::1 2001:db8::2 2001:db8::3 192.0.2.1 192.0.2.2", 1, "2001:db8::1 192.0.2.1 2001:db8::2 192.0.2.2 2001:db8::3"},
+		{"first count", "2001:db8::1 2001:db8::2 2001:db8::3 192.0.2.1 192.0.2.2", 2, "2001:db8::1 2001:db8::2 192.0.2.1 2001:db8::3 192.0.2.2"},
+		{"ipv4 first", "192.0.2.1 192.0.2.2 2001:db8::1", 1, "192.0.2.1 2001:db8::1 192.0.2.2"},
+		{"one family", "192.0.2.1 192.0.2.2", 1, "192.0.2.1 192.0.2.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var addrs []netip.Addr
+			for _, s := range strings.Fields(tt.addrs) {
+				addrs = append(addrs, netip.MustParseAddr(s))
+			}
+			var got []string
+			for _, a := range interleave(addrs, tt.firstCount) {
+				got = append(got, a.String())
+			}
+			if !slices.Equal(got, strings.Fields(tt.want)) {
+				t.Errorf("interleave() = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// listen4 returns a TCP listener on 127.0.0.1 accepting connections
+func listen4(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	return ln
+}
+
+func TestHappyEyeballsFallback(t *testing.T) {
+	ln := listen4(t)
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+	if l6, err := net.Listen("tcp6", "[::1]:0"); err != nil {
+		t.Skip("no IPv6:", err)
+	} else {
+		l6.Close()
+	}
+
+	// the IPv6 attempts hang until canceled (broken IPv6), IPv4 wins after the attempt delay
+	d := HappyEyeballsDialer{ConnectionAttemptDelay: 50 * time.Millisecond}
+	d.Dialer.ControlContext = func(ctx context.Context, network, address string, c syscall.RawConn) error {
+		if network == "tcp6" {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	conn, trace, err := d.DialContext(ctx, "tcp", net.JoinHostPort("::1", strconv.Itoa(port)))
+	if err == nil {
+		conn.Close()
+		t.Fatalf("dial of ::1 only should fail:\n%s", trace)
+	}
+	if len(trace.Attempts) != 1 || trace.Winner != -1 {
+		t.Errorf("::1 trace:\n%s", trace)
+	}
+
+	// a literal address: a single attempt
+	conn, trace, err = d.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if len(trace.Attempts) != 1 || trace.Winner != 0 || trace.Version() != 4 || len(trace.Resolutions) != 0 {
+		t.Errorf("literal address trace:\n%s", trace)
+	}
+
+	// a name with both families
+	addrs := []netip.Addr{netip.MustParseAddr("::1"), netip.MustParseAddr("127.0.0.1")}
+	d.Resolver = testResolver(addrs, 0)
+	conn, trace, err = d.Dial("tcp", net.JoinHostPort("dual.nspeed.test", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatalf("%v:\n%s", err, trace)
+	}
+	conn.Close()
+	if trace.Version() != 4 || len(trace.Attempts) != 2 || !trace.Attempts[0].Address.Addr().Is6() || len(trace.Resolutions) != 2 {
+		t.Fatalf("trace:\n%s", trace)
+	}
+	if a := trace.Attempts[1]; a.Start < d.ConnectionAttemptDelay {
+		t.Errorf("IPv4 attempt started at %s, before the attempt delay %s", a.Start, d.ConnectionAttemptDelay)
+	}
+	if trace.Attempts[0].Err != context.Canceled {
+		t.Errorf("IPv6 attempt error = %v, want canceled", trace.Attempts[0].Err)
+	}
+
+	// a late AAAA answer (after the resolution delay): IPv4 is tried first
+	d.Resolver = testResolver(addrs, 200*time.Millisecond)
+	conn, trace, err = d.Dial("tcp", net.JoinHostPort("dual.nspeed.test", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatalf("%v:\n%s", err, trace)
+	}
+	conn.Close()
+	if trace.Version() != 4 || trace.Winner != 0 || trace.Attempts[0].Start < DefaultResolutionDelay {
+		t.Errorf("late AAAA trace:\n%s", trace)
+	}
+}
+
+// testResolver returns a resolver answering any name with addrs, the AAAA answers are delayed by delay6
+func testResolver(addrs []netip.Addr, delay6 time.Duration) *net.Resolver {
+	return &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+		c, s := net.Pipe() // not a net.PacketConn: the messages are framed like TCP
+		go serveDNS(s, addrs, delay6)
+		return c, nil
+	}}
+}
+
+func serveDNS(c net.Conn, addrs []netip.Addr, delay6 time.Duration) {
+	defer c.Close()
+	for {
+		var l [2]byte
+		if _, err := io.ReadFull(c, l[:]); err != nil {
+			return
+		}
+		b := make([]byte, binary.BigEndian.Uint16(l[:]))
+		if _, err := io.ReadFull(c, b); err != nil {
+			return
+		}
+		var p dnsmessage.Parser
+		h, err := p.Start(b)
+		if err != nil {
+			return
+		}
+		q, err := p.Question()
+		if err != nil {
+			return
+		}
+		rb := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: h.ID, Response: true, RecursionDesired: h.RecursionDesired, RecursionAvailable: true})
+		rb.StartQuestions()
+		rb.Question(q)
+		rb.StartAnswers()
+		rh := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 60}
+		for _, a := range addrs {
+			switch {
+			case q.Type == dnsmessage.TypeA && a.Is4():
+				rb.AResource(rh, dnsmessage.AResource{A: a.As4()})
+			case q.Type == dnsmessage.TypeAAAA && a.Is6():
+				rb.AAAAResource(rh, dnsmessage.AAAAResource{AAAA: a.As16()})
+			}
+		}
+		msg, err := rb.Finish()
+		if err != nil {
+			return
+		}
+		if q.Type == dnsmessage.TypeAAAA {
+			time.Sleep(delay6)
+		}
+		if _, err = c.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(msg))), msg...)); err != nil {
+			return
+		}
+	}
+}
+
+func TestHappyEyeballsLateAAAA(t *testing.T) {
+	ln, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skip("no IPv6:", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	// the IPv4 attempt hangs (black hole), the AAAA answer arrives after its attempt delay
+	d := HappyEyeballsDialer{ConnectionAttemptDelay: 50 * time.Millisecond}
+	d.Dialer.ControlContext = func(ctx context.Context, network, address string, c syscall.RawConn) error {
+		if network == "tcp4" {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	}
+	d.Resolver = testResolver([]netip.Addr{netip.MustParseAddr("::1"), netip.MustParseAddr("127.0.0.1")}, 200*time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	conn, trace, err := d.DialContext(ctx, "tcp", net.JoinHostPort("dual.nspeed.test", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatalf("%v:\n%s", err, trace)
+	}
+	conn.Close()
+	if trace.Version() != 6 || len(trace.Attempts) != 2 || trace.Winner != 1 || trace.Attempts[1].Start > time.Second {
+		t.Errorf("trace:\n%s", trace)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"nspeed.app/nspeed/humanize"
+)
+
+// ThroughputSample is the traffic of an interface during an interval of an InterfaceMonitor
+type ThroughputSample struct {
+	Elapsed  time.Duration // end of the interval since the start of the monitor
+	Duration time.Duration // of the interval
+	RxBytes  int64
+	TxBytes  int64
+}
+
+// RxBitPerSecond returns the received throughput in bit per second, -1 if the interval is below 1ms
+func (s ThroughputSample) RxBitPerSecond() int64 {
+	return humanize.BitPerSecondFromInt64(s.RxBytes, s.Duration)
+}
+
+// TxBitPerSecond returns the sent throughput in bit per second, -1 if the interval is below 1ms
+func (s ThroughputSample) TxBitPerSecond() int64 {
+	return humanize.BitPerSecondFromInt64(s.TxBytes, s.Duration)
+}
+
+func (s ThroughputSample) String() string {
+	return fmt.Sprintf("%s rx %s tx %s", s.Elapsed.Round(time.Millisecond),
+		humanize.FormatBitperSecond(s.RxBytes, s.Duration), humanize.FormatBitperSecond(s.TxBytes, s.Duration))
+}
+
+// InterfaceMonitor samples the byte counters of interfaces periodically, all the traffic of the host
+// (not only the traffic of a test) to detect cross traffic. Linux only, see GetNICCounters.
+type InterfaceMonitor struct {
+	names   []string
+	start   time.Time
+	last    time.Time
+	prev    map[string]NICCounters
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+	mu      sync.Mutex
+	series  map[string][]ThroughputSample
+	err     error
+}
+
+// NewInterfaceMonitor starts sampling the interfaces names (all if nil, see GetNetInterfaces)
+// every rate (at least 1ms). The interfaces are the ones at the start.
+func NewInterfaceMonitor(names []string, rate time.Duration) (*InterfaceMonitor, error) {
+	if rate < time.Millisecond {
+		return nil, fmt.Errorf("invalid rate: %s", rate)
+	}
+	ifaces, err := GetNetInterfaces(names)
+	if err != nil {
+		return nil, err
+	}
+	m := &InterfaceMonitor{done: make(chan struct{}), stopped: make(chan struct{}), series: make(map[string][]ThroughputSample)}
+	for _, iface := range ifaces {
+		m.names = append(m.names, iface.Name)
+	}
+	m.start = time.Now()
+	if m.prev, err = GetNICCounters(m.names); err != nil {
+		return nil, err
+	}
+	m.last = m.start
+	go m.run(rate)
+	return m, nil
+}
+
+func (m *InterfaceMonitor) run(rate time.Duration) {
+	defer close(m.stopped)
+	ticker := time.NewTicker(rate)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			if m.sample() != nil {
+				return
+			}
+		}
+	}
+}
+
+// sample records a sample of every interface, the first error stops the sampling
+func (m *InterfaceMonitor) sample() error {
+	now := time.Now()
+	counters, err := GetNICCounters(m.names)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		if m.err == nil {
+			m.err = err
+		}
+		return err
+	}
+	for _, name := range m.names {
+		d := counters[name].Sub(m.prev[name])
+		m.series[name] = append(m.series[name], ThroughputSample{
+			Elapsed:  now.Sub(m.start),
+			Duration: now.Sub(m.last),
+			RxBytes:  int64(d.RxBytes),
+			TxBytes:  int64(d.TxBytes),
+		})
+	}
+	m.prev, m.last = counters, now
+	return nil
+}
+
+// Interfaces returns the names of the monitored interfaces
+func (m *InterfaceMonitor) Interfaces() []string {
+	return append([]string(nil), m.names...)
+}
+
+// Series returns a copy of the samples of the interface name taken so far
+func (m *InterfaceMonitor) Series(name string) []ThroughputSample {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]ThroughputSample(nil), m.series[name]...)
+}
+
+// Total returns the traffic of the interface name since the start of the monitor (until Stop)
+func (m *InterfaceMonitor) Total(name string) ThroughputSample {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var t ThroughputSample
+	for _, s := range m.series[name] {
+		t.RxBytes += s.RxBytes
+		t.TxBytes += s.TxBytes
+		t.Elapsed = s.Elapsed
+	}
+	t.Duration = t.Elapsed
+	return t
+}
+
+// Err returns the error which stopped the sampling (a removed interface for instance), if any
+func (m *InterfaceMonitor) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+// Stop takes a last sample (if the last interval is at least 1ms) and stops the sampling
+func (m *InterfaceMonitor) Stop() {
+	m.once.Do(func() {
+		close(m.done)
+		<-m.stopped
+		m.mu.Lock()
+		last := m.last
+		m.mu.Unlock()
+		if m.Err() == nil && time.Since(last) >= time.Millisecond {
+			_ = m.sample()
+		}
+	})
+}
@@ -0,0 +1,74 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestInterfaceMonitor(t *testing.T) {
+	lo := loopback(t)
+	if _, err := NewInterfaceMonitor([]string{lo}, 0); err == nil {
+		t.Error("NewInterfaceMonitor() with a 0 rate should fail")
+	}
+	if _, err := NewInterfaceMonitor([]string{"nspeed-none"}, time.Second); err == nil {
+		t.Error("NewInterfaceMonitor() of an unknown interface should fail")
+	}
+
+	ln, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	c, err := net.Dial("udp4", ln.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	m, err := NewInterfaceMonitor([]string{lo}, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(m.Interfaces(), []string{lo}) {
+		t.Errorf("Interfaces() = %v", m.Interfaces())
+	}
+	const size = 1 << 20
+	b := make([]byte, 1000)
+	for sent := 0; sent < size; sent += len(b) {
+		c.Write(b)
+		if sent%(100<<10) == 0 {
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	time.Sleep(30 * time.Millisecond)
+	m.Stop()
+	m.Stop()
+	if err = m.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	series := m.Series(lo)
+	if len(series) < 2 {
+		t.Fatalf("Series() = %v", series)
+	}
+	var peak int64
+	for i, s := range series {
+		if s.Duration <= 0 || (i > 0 && s.Elapsed <= series[i-1].Elapsed) {
+			t.Errorf("sample %d: %+v", i, s)
+		}
+		peak = max(peak, s.TxBitPerSecond())
+	}
+	if peak <= 0 {
+		t.Errorf("no traffic in %v", series)
+	}
+	total := m.Total(lo)
+	if total.TxBytes < size || total.Elapsed != series[len(series)-1].Elapsed || total.TxBitPerSecond() <= 0 {
+		t.Errorf("Total() = %+v (%s)", total, total)
+	}
+}
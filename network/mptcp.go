@@ -0,0 +1,99 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// MPTCPMode is the Multipath TCP mode of TCPDialer and TCPListenConfig
+type MPTCPMode int
+
+const (
+	MPTCPDefault  MPTCPMode = iota // the Go default: disabled when dialing, enabled when listening if the system supports it
+	MPTCPEnabled                   // Multipath TCP if the system supports it, else TCP
+	MPTCPDisabled                  // TCP only
+)
+
+func (m MPTCPMode) String() string {
+	switch m {
+	case MPTCPDefault:
+		return "default"
+	case MPTCPEnabled:
+		return "on"
+	case MPTCPDisabled:
+		return "off"
+	default:
+		return fmt.Sprintf("MPTCPMode(%d)", int(m))
+	}
+}
+
+// ParseMPTCPMode parses a MPTCPMode ("default", "on" or "off")
+func ParseMPTCPMode(s string) (MPTCPMode, error) {
+	for _, m := range []MPTCPMode{MPTCPDefault, MPTCPEnabled, MPTCPDisabled} {
+		if s == m.String() {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid MPTCP mode: %s", s)
+}
+
+// apply applies the mode with the SetMultipathTCP method of a dialer or a listen configuration
+func (m MPTCPMode) apply(set func(bool)) {
+	switch m {
+	case MPTCPEnabled:
+		set(true)
+	case MPTCPDisabled:
+		set(false)
+	}
+}
+
+// MPTCPInfo is the Multipath TCP state of a connection (Linux)
+type MPTCPInfo struct {
+	Used            bool   // Multipath TCP was negotiated with the peer, false if it fell back to TCP
+	Token           uint32 // connection token
+	Subflows        int    // additional subflows, the initial one excluded
+	SubflowsMax     int    // limit of additional subflows (path manager)
+	AddAddrSignal   int    // addresses announced to the peer
+	AddAddrAccepted int    // addresses announced by the peer and accepted
+	BytesSent       uint64 // including the retransmissions, 0 before Linux 6.6
+	BytesReceived   uint64
+	BytesAcked      uint64
+	BytesRetrans    uint64
+	SubflowList     []MPTCPSubflow // all the subflows, empty before Linux 5.16
+}
+
+// MPTCPSubflow is a subflow of a Multipath TCP connection
+type MPTCPSubflow struct {
+	Local   netip.AddrPort
+	Remote  netip.AddrPort
+	TCPInfo TCPInfo
+}
+
+func (s MPTCPSubflow) String() string {
+	return fmt.Sprintf("%s -> %s %s", s.Local, s.Remote, s.TCPInfo)
+}
+
+// GetMPTCPInfo returns the Multipath TCP state of conn, a TCP connection or a wrapper (see TCPConnOf).
+// Used is false for a TCP connection.
+func GetMPTCPInfo(conn net.Conn) (MPTCPInfo, error) {
+	tc, err := TCPConnOf(conn)
+	if err != nil {
+		return MPTCPInfo{}, err
+	}
+	used, err := tc.MultipathTCP()
+	if err != nil || !used {
+		return MPTCPInfo{}, err
+	}
+	rc, err := tc.SyscallConn()
+	if err != nil {
+		return MPTCPInfo{}, err
+	}
+	info, err := getMPTCPInfo(rc)
+	info.Used = true
+	return info, err
+}
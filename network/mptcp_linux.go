@@ -0,0 +1,138 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// SOL_MPTCP options of include/uapi/linux/mptcp.h
+const (
+	mptcpInfo          = 1 // struct mptcp_info
+	mptcpTCPInfo       = 2 // struct mptcp_subflow_data and the struct tcp_info of the subflows (Linux 5.16)
+	mptcpSubflowAddrs  = 3 // struct mptcp_subflow_data and the struct mptcp_subflow_addrs of the subflows (Linux 5.16)
+	sizeofSubflowData  = 16
+	sizeofSubflowAddrs = 2 * 128 // two struct sockaddr_storage
+)
+
+// getsockopt gets a socket option in b, returns the length set by the kernel
+func getsockopt(fd, level, opt int, b []byte) (int, error) {
+	l := uint32(len(b))
+	_, _, errno := unix.Syscall6(unix.SYS_GETSOCKOPT, uintptr(fd), uintptr(level), uintptr(opt),
+		uintptr(unsafe.Pointer(&b[0])), uintptr(unsafe.Pointer(&l)), 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(l), nil
+}
+
+func getMPTCPInfo(rc syscall.RawConn) (MPTCPInfo, error) {
+	var r MPTCPInfo
+	var err error
+	now := time.Now()
+	cerr := rc.Control(func(fd uintptr) {
+		s := int(fd)
+		b := make([]byte, 96)
+		var n int
+		if n, err = getsockopt(s, unix.SOL_MPTCP, mptcpInfo, b); err != nil {
+			return
+		}
+		r.parse(b[:n])
+		var addrs, infos [][]byte
+		addrs, err = getSubflowData(s, mptcpSubflowAddrs, sizeofSubflowAddrs)
+		if err == nil {
+			infos, err = getSubflowData(s, mptcpTCPInfo, int(unsafe.Sizeof(unix.TCPInfo{})))
+		}
+		if errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.ENOPROTOOPT) {
+			err = nil // before Linux 5.16
+			return
+		}
+		if err != nil {
+			return
+		}
+		for i, a := range addrs {
+			sf := MPTCPSubflow{Local: parseSockaddr(a[:128]), Remote: parseSockaddr(a[128:])}
+			if i < len(infos) {
+				var info unix.TCPInfo
+				copy(unsafe.Slice((*byte)(unsafe.Pointer(&info)), unsafe.Sizeof(info)), infos[i])
+				sf.TCPInfo = newTCPInfo(now, &info)
+			}
+			r.SubflowList = append(r.SubflowList, sf)
+		}
+	})
+	if cerr != nil {
+		return r, cerr
+	}
+	return r, err
+}
+
+// parse sets the fields of struct mptcp_info, the fields unknown to older kernels are 0
+func (r *MPTCPInfo) parse(b []byte) {
+	if len(b) < 48 {
+		return
+	}
+	ne := binary.NativeEndian
+	r.Subflows = int(b[0])
+	r.AddAddrSignal = int(b[1])
+	r.AddAddrAccepted = int(b[2])
+	r.SubflowsMax = int(b[3])
+	r.Token = ne.Uint32(b[12:])
+	if len(b) >= 80 {
+		r.BytesRetrans = ne.Uint64(b[48:])
+		r.BytesSent = ne.Uint64(b[56:])
+		r.BytesReceived = ne.Uint64(b[64:])
+		r.BytesAcked = ne.Uint64(b[72:])
+	}
+}
+
+// getSubflowData returns the elements of size bytes of a struct mptcp_subflow_data option
+func getSubflowData(s, opt, size int) ([][]byte, error) {
+	count := 8
+	for {
+		b := make([]byte, sizeofSubflowData+count*size)
+		ne := binary.NativeEndian
+		ne.PutUint32(b[0:], sizeofSubflowData) // size_subflow_data
+		ne.PutUint32(b[12:], uint32(size))     // size_user
+		n, err := getsockopt(s, unix.SOL_MPTCP, opt, b)
+		if err != nil {
+			return nil, err
+		}
+		num := int(ne.Uint32(b[4:]))
+		if num > count {
+			count = num
+			continue
+		}
+		elem := min(int(ne.Uint32(b[12:])), size) // the kernel size if smaller
+		var r [][]byte
+		for i := range num {
+			off := sizeofSubflowData + i*elem
+			if off+elem > n {
+				break
+			}
+			r = append(r, b[off:off+elem])
+		}
+		return r, nil
+	}
+}
+
+// parseSockaddr parses a struct sockaddr_in or sockaddr_in6
+func parseSockaddr(b []byte) netip.AddrPort {
+	port := binary.BigEndian.Uint16(b[2:])
+	switch binary.NativeEndian.Uint16(b) {
+	case unix.AF_INET:
+		return netip.AddrPortFrom(netip.AddrFrom4([4]byte(b[4:8])), port)
+	case unix.AF_INET6:
+		return netip.AddrPortFrom(netip.AddrFrom16([16]byte(b[8:24])).Unmap(), port)
+	default:
+		return netip.AddrPort{}
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestGetMPTCPInfo(t *testing.T) {
+	if b, err := os.ReadFile("/proc/sys/net/mptcp/enabled"); err != nil || strings.TrimSpace(string(b)) != "1" {
+		t.Skip("MPTCP is not enabled")
+	}
+	for _, mode := range []MPTCPMode{MPTCPEnabled, MPTCPDisabled} {
+		t.Run(mode.String(), func(t *testing.T) {
+			lc := TCPListenConfig{Options: TCPOptions{Multipath: mode}}
+			ln, err := lc.Listen(context.Background(), "tcp4", "127.0.0.1:0")
+			if err != nil {
+				t.Skip(err)
+			}
+			defer ln.Close()
+			go func() {
+				c, err := ln.Accept()
+				if err == nil {
+					io.Copy(io.Discard, c)
+					c.Close()
+				}
+			}()
+			d := TCPDialer{Options: TCPOptions{Multipath: mode}}
+			conn, err := d.Dial("tcp4", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if _, err = conn.Write(make([]byte, 64<<10)); err != nil {
+				t.Fatal(err)
+			}
+
+			info, err := GetMPTCPInfo(NewBufferedConn(conn, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mode == MPTCPDisabled {
+				if info.Used {
+					t.Errorf("GetMPTCPInfo() = %+v, want not used", info)
+				}
+				return
+			}
+			if !info.Used || info.Token == 0 {
+				t.Fatalf("GetMPTCPInfo() = %+v", info)
+			}
+			if len(info.SubflowList) == 0 {
+				t.Skip("no subflow list (Linux < 5.16)")
+			}
+			sf := info.SubflowList[0]
+			if sf.Local.String() != conn.LocalAddr().String() || sf.Remote.String() != conn.RemoteAddr().String() ||
+				sf.TCPInfo.State != "established" || sf.TCPInfo.SndMSS == 0 {
+				t.Errorf("subflow %s, connection %s -> %s", sf, conn.LocalAddr(), conn.RemoteAddr())
+			}
+		})
+	}
+}
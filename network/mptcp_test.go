@@ -0,0 +1,19 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import "testing"
+
+func TestMPTCPMode(t *testing.T) {
+	for _, s := range []string{"default", "on", "off"} {
+		m, err := ParseMPTCPMode(s)
+		if err != nil || m.String() != s {
+			t.Errorf("ParseMPTCPMode(%q) = %v, %v", s, m, err)
+		}
+	}
+	if _, err := ParseMPTCPMode("foo"); err == nil {
+		t.Error("ParseMPTCPMode(foo) should fail")
+	}
+}
@@ -62,3 +62,23 @@ func GetRouteFromAddrPort(destination string) (iface *net.Interface, gw netip.Ad
 	}
 	return GetRoute(ip)
 }
+
+// RouteOptions are the hints of GetRouteWithOptions: what a socket is bound to.
+// They're the 'from', 'oif' and 'mark' of "ip route get" on Linux.
+type RouteOptions struct {
+	Source    netip.Addr // source address, invalid if none
+	Interface string     // outbound interface name, empty if none
+	Mark      uint32     // socket mark (SO_MARK), 0 if none
+}
+
+// GetRouteWithOptions returns the route used to reach destination, a literal IP address (v4 or v6),
+// by a socket bound to the options. Unlike GetRoute, the kernel is asked so
+// the policy routing rules ("ip rule") apply: on multi-homed hosts it's the real egress path.
+// It's only supported on Linux, except without options.
+func GetRouteWithOptions(destination string, options RouteOptions) (Route, error) {
+	ap, err := netip.ParseAddr(destination)
+	if err != nil {
+		return Route{}, fmt.Errorf("parse destination ip error: %w", err)
+	}
+	return lookupRoute(ap.Unmap(), options)
+}
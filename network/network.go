@@ -5,38 +5,28 @@
 package network
 
 import (
-	"context"
 	"errors"
 	"fmt"
 	"net"
 	"net/netip"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 )
 
 // SetDNSServer specifies a custom DNS server (globally).
-// The parameter must be a literal IP address or literal IP:port combination.
+// The parameter must be a literal IP address or literal IP:port combination,
+// or a tcp://, tls:// or https:// URL (see ParseDNSServer), its host name is resolved once with the previous resolver.
 // if no port, 53 will be used.
-
+// Use a DNSResolver to query a server without changing the global resolver.
 func SetDNSServer(address string) error {
-	addrPort, err := netip.ParseAddrPort(address)
+	r, err := NewDNSResolver(address)
 	if err != nil {
-		addr, err2 := netip.ParseAddr(address)
-		if err2 != nil {
-			return err2
-		}
-		addrPort = netip.AddrPortFrom(addr, 53)
+		return err
 	}
 	net.DefaultResolver.PreferGo = true
-	net.DefaultResolver.Dial = func(context context.Context, network, address string) (net.Conn, error) {
-		var d net.Dialer
-		conn, err := d.DialContext(context, "udp", addrPort.String())
-		if err != nil {
-			return nil, err
-		}
-		return conn, nil
-	}
+	net.DefaultResolver.Dial = r.Resolver().Dial
 	return nil
 }
 
@@ -165,23 +155,76 @@ func ResolveInterfaceHostAddress(host string) ([]net.IPAddr, error) {
 
 // InterfaceAddress returns the address of interface if parameter host is an interface name.
 // If ipVersion is not 0, the corresponding version is selected.
-
+// The address is the RFC 6724 preferred source without a destination, see InterfaceAddressFor.
 func InterfaceAddress(host string, ipVersion IPVersion) (*net.IPAddr, error) {
+	return InterfaceAddressFor(host, ipVersion, netip.Addr{})
+}
+
+// InterfaceAddressFor is InterfaceAddress for a destination: if host is an interface name,
+// its source address for destination is selected with the RFC 6724 rules (scope, deprecated, label,
+// temporary, longest matching prefix). If ipVersion is 0, the version of destination is used.
+// Without a valid destination, the address with the largest scope is preferred, then a not deprecated,
+// higher precedence (IPv6 before IPv4) and temporary one.
+func InterfaceAddressFor(host string, ipVersion IPVersion, destination netip.Addr) (*net.IPAddr, error) {
 	if host == "" {
 		return nil, nil
 	}
-	la, err := ResolveInterfaceHostAddress(host)
+	if destination.IsValid() {
+		destination = destination.Unmap()
+		if ipVersion == 0 {
+			ipVersion = GetIPVersion(destination)
+		}
+		if ipVersion != GetIPVersion(destination) {
+			return nil, fmt.Errorf("destination %s is not %s", destination, ipVersion)
+		}
+	}
+	itf, err := net.InterfaceByName(host)
+	if err != nil {
+		// not an interface name: the candidates are the addresses of host
+		la, err := ResolveInterfaceHostAddress(host)
+		if err != nil {
+			return nil, fmt.Errorf("bad local host address: %s (%w)", host, err)
+		}
+		sources := make([]SourceAddress, 0, len(la))
+		for _, a := range la {
+			if addr, ok := netip.AddrFromSlice(a.IP); ok {
+				addr = addr.Unmap()
+				sources = append(sources, SourceAddress{Prefix: netip.PrefixFrom(addr, addr.BitLen())})
+			}
+		}
+		s, ok := selectSource(destination, sources, ipVersion)
+		if !ok {
+			return nil, fmt.Errorf("no valid candidate address found for %s", host)
+		}
+		// the resolved address keeps its zone
+		i := slices.IndexFunc(la, func(a net.IPAddr) bool { return a.IP.Equal(s.Addr().AsSlice()) })
+		return &la[i], nil
+	}
+	if itf.Flags&net.FlagUp == 0 {
+		return nil, fmt.Errorf("bad local host address: %s (interface is down: %s)", host, itf.Name)
+	}
+	sources, err := GetSourceAddresses(itf.Name)
 	if err != nil {
 		return nil, fmt.Errorf("bad local host address: %s (%w)", host, err)
 	}
-
-	fhostIPAddrs := FilterAddresses(la, ipVersion)
-
-	if len(fhostIPAddrs) == 0 {
+	s, ok := selectSource(destination, sources, ipVersion)
+	if !ok {
 		return nil, fmt.Errorf("no valid candidate address found for %s", host)
 	}
+	ipa := &net.IPAddr{IP: s.Addr().AsSlice()}
+	if s.Addr().Is6() && s.Addr().IsLinkLocalUnicast() {
+		ipa.Zone = itf.Name
+	}
+	return ipa, nil
+}
 
-	return &fhostIPAddrs[0], nil
+// selectSource returns the source address for destination among sources, the preferred one of ipVersion
+// without a valid destination.
+func selectSource(destination netip.Addr, sources []SourceAddress, ipVersion IPVersion) (SourceAddress, bool) {
+	if destination.IsValid() {
+		return SelectSource(destination, sources)
+	}
+	return preferredSource(sources, ipVersion)
 }
 
 // FilterAddresses
@@ -0,0 +1,146 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"fmt"
+	"time"
+
+	"nspeed.app/nspeed/humanize"
+)
+
+// NICCounters are the counters of a network interface, the columns of /proc/net/dev
+type NICCounters struct {
+	RxBytes      uint64
+	RxPackets    uint64
+	RxErrors     uint64
+	RxDropped    uint64
+	RxFifo       uint64
+	RxFrame      uint64
+	RxCompressed uint64
+	RxMulticast  uint64
+	TxBytes      uint64
+	TxPackets    uint64
+	TxErrors     uint64
+	TxDropped    uint64
+	TxFifo       uint64
+	TxCollisions uint64
+	TxCarrier    uint64
+	TxCompressed uint64
+}
+
+// Sub returns the counters c minus the counters before. A counter lower than before was reset
+// (the interface was recreated), its value is kept.
+func (c NICCounters) Sub(before NICCounters) NICCounters {
+	sub := func(a, b uint64) uint64 {
+		if a < b {
+			return a
+		}
+		return a - b
+	}
+	return NICCounters{
+		RxBytes:      sub(c.RxBytes, before.RxBytes),
+		RxPackets:    sub(c.RxPackets, before.RxPackets),
+		RxErrors:     sub(c.RxErrors, before.RxErrors),
+		RxDropped:    sub(c.RxDropped, before.RxDropped),
+		RxFifo:       sub(c.RxFifo, before.RxFifo),
+		RxFrame:      sub(c.RxFrame, before.RxFrame),
+		RxCompressed: sub(c.RxCompressed, before.RxCompressed),
+		RxMulticast:  sub(c.RxMulticast, before.RxMulticast),
+		TxBytes:      sub(c.TxBytes, before.TxBytes),
+		TxPackets:    sub(c.TxPackets, before.TxPackets),
+		TxErrors:     sub(c.TxErrors, before.TxErrors),
+		TxDropped:    sub(c.TxDropped, before.TxDropped),
+		TxFifo:       sub(c.TxFifo, before.TxFifo),
+		TxCollisions: sub(c.TxCollisions, before.TxCollisions),
+		TxCarrier:    sub(c.TxCarrier, before.TxCarrier),
+		TxCompressed: sub(c.TxCompressed, before.TxCompressed),
+	}
+}
+
+// Problems returns the number of errors and drops, received and sent
+func (c NICCounters) Problems() uint64 {
+	return c.RxErrors + c.RxDropped + c.RxFifo + c.RxFrame + c.TxErrors + c.TxDropped + c.TxFifo + c.TxCollisions + c.TxCarrier
+}
+
+func (c NICCounters) String() string {
+	return fmt.Sprintf("rx %s %d packets %d errors %d dropped, tx %s %d packets %d errors %d dropped",
+		humanize.ByteCountDecimal(int64(c.RxBytes)), c.RxPackets, c.RxErrors, c.RxDropped,
+		humanize.ByteCountDecimal(int64(c.TxBytes)), c.TxPackets, c.TxErrors, c.TxDropped)
+}
+
+// NICInfo is the information of a network interface (Linux)
+type NICInfo struct {
+	Name           string
+	Index          int
+	MTU            int
+	Speed          int    // Mb/s, -1 if unknown (virtual interfaces ...)
+	Duplex         string // "full", "half" or "unknown"
+	Driver         string // empty for a virtual interface
+	OperState      string // RFC 2863 state: "up", "down", "unknown" ...
+	RxQueues       int
+	TxQueues       int
+	TxQueueLen     int
+	CarrierChanges int
+	Counters       NICCounters
+}
+
+func (i NICInfo) String() string {
+	speed := "unknown"
+	if i.Speed >= 0 {
+		speed = fmt.Sprintf("%d Mb/s", i.Speed)
+	}
+	return fmt.Sprintf("%s: %s speed %s duplex %s mtu %d driver %s queues %d/%d qlen %d carrier changes %d\n  %s",
+		i.Name, i.OperState, speed, i.Duplex, i.MTU, i.Driver, i.RxQueues, i.TxQueues, i.TxQueueLen, i.CarrierChanges, i.Counters)
+}
+
+// NICSnapshot is the information of network interfaces at a time, see TakeNICSnapshot
+type NICSnapshot struct {
+	Time       time.Time
+	Interfaces []NICInfo
+}
+
+// TakeNICSnapshot returns the information of the interfaces names (all if nil), see GetNICInfo
+func TakeNICSnapshot(names []string) (NICSnapshot, error) {
+	now := time.Now()
+	infos, err := GetNICInfo(names)
+	return NICSnapshot{Time: now, Interfaces: infos}, err
+}
+
+// NICDelta is the change of the counters of an interface between two snapshots
+type NICDelta struct {
+	Name           string
+	Duration       time.Duration
+	Counters       NICCounters
+	CarrierChanges int
+}
+
+func (d NICDelta) String() string {
+	s := fmt.Sprintf("%s in %s: %s", d.Name, d.Duration.Round(time.Millisecond), d.Counters)
+	if d.CarrierChanges > 0 {
+		s += fmt.Sprintf(", %d carrier changes", d.CarrierChanges)
+	}
+	return s
+}
+
+// Delta returns the changes since the snapshot before, for the interfaces of both snapshots
+func (s NICSnapshot) Delta(before NICSnapshot) []NICDelta {
+	var r []NICDelta
+	for _, i := range s.Interfaces {
+		for _, b := range before.Interfaces {
+			if i.Name != b.Name {
+				continue
+			}
+			r = append(r, NICDelta{
+				Name:           i.Name,
+				Duration:       s.Time.Sub(before.Time),
+				Counters:       i.Counters.Sub(b.Counters),
+				CarrierChanges: max(i.CarrierChanges-b.CarrierChanges, 0),
+			})
+			break
+		}
+	}
+	return r
+}
@@ -0,0 +1,143 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// GetNICInfo returns the information of the interfaces names (all if nil, see GetNetInterfaces)
+// from /sys/class/net and /proc/net/dev
+func GetNICInfo(names []string) ([]NICInfo, error) {
+	ifaces, err := GetNetInterfaces(names)
+	if err != nil {
+		return nil, err
+	}
+	counters, err := readProcNetDev()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]NICInfo, 0, len(ifaces))
+	for _, iface := range ifaces {
+		info := readNICInfo("/sys/class/net", iface)
+		info.Counters = counters[iface.Name]
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// GetNICCounters returns the counters of the interfaces names (all if nil, see GetNetInterfaces) by name,
+// it only reads /proc/net/dev
+func GetNICCounters(names []string) (map[string]NICCounters, error) {
+	counters, err := readProcNetDev()
+	if err != nil || names == nil {
+		return counters, err
+	}
+	r := make(map[string]NICCounters, len(names))
+	for _, n := range names {
+		c, ok := counters[n]
+		if !ok {
+			return nil, fmt.Errorf("interface \"%s\" not found", n)
+		}
+		r[n] = c
+	}
+	return r, nil
+}
+
+func readProcNetDev() (map[string]NICCounters, error) {
+	fd, err := os.Open("/proc/net/dev")
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	return parseProcNetDev(fd)
+}
+
+// readNICInfo reads the information of iface in the sysfs directory dir, the missing files are ignored
+func readNICInfo(dir string, iface net.Interface) NICInfo {
+	dir = filepath.Join(dir, iface.Name)
+	read := func(name string) string {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(b))
+	}
+	readInt := func(name string, def int) int {
+		v, err := strconv.Atoi(read(name))
+		if err != nil {
+			return def
+		}
+		return v
+	}
+	info := NICInfo{
+		Name:           iface.Name,
+		Index:          iface.Index,
+		MTU:            iface.MTU,
+		Speed:          readInt("speed", -1), // EINVAL if there's no carrier
+		Duplex:         read("duplex"),
+		OperState:      read("operstate"),
+		TxQueueLen:     readInt("tx_queue_len", 0),
+		CarrierChanges: readInt("carrier_changes", 0),
+	}
+	if info.Duplex == "" {
+		info.Duplex = "unknown"
+	}
+	if driver, err := os.Readlink(filepath.Join(dir, "device", "driver")); err == nil {
+		info.Driver = filepath.Base(driver)
+	}
+	if queues, err := os.ReadDir(filepath.Join(dir, "queues")); err == nil {
+		for _, q := range queues {
+			switch {
+			case strings.HasPrefix(q.Name(), "rx-"):
+				info.RxQueues++
+			case strings.HasPrefix(q.Name(), "tx-"):
+				info.TxQueues++
+			}
+		}
+	}
+	return info
+}
+
+// parseProcNetDev parses /proc/net/dev, returns the counters by interface name
+func parseProcNetDev(r io.Reader) (map[string]NICCounters, error) {
+	counters := make(map[string]NICCounters)
+	scanner := bufio.NewScanner(r)
+	for line := 0; scanner.Scan(); line++ {
+		if line < 2 {
+			continue // headers
+		}
+		name, values, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			return nil, fmt.Errorf("invalid /proc/net/dev line: %s", scanner.Text())
+		}
+		fields := strings.Fields(values)
+		if len(fields) < 16 {
+			return nil, fmt.Errorf("invalid /proc/net/dev line: %s", scanner.Text())
+		}
+		var v [16]uint64
+		for i := range v {
+			n, err := strconv.ParseUint(fields[i], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid /proc/net/dev line: %s", scanner.Text())
+			}
+			v[i] = n
+		}
+		counters[strings.TrimSpace(name)] = NICCounters{
+			RxBytes: v[0], RxPackets: v[1], RxErrors: v[2], RxDropped: v[3],
+			RxFifo: v[4], RxFrame: v[5], RxCompressed: v[6], RxMulticast: v[7],
+			TxBytes: v[8], TxPackets: v[9], TxErrors: v[10], TxDropped: v[11],
+			TxFifo: v[12], TxCollisions: v[13], TxCarrier: v[14], TxCompressed: v[15],
+		}
+	}
+	return counters, scanner.Err()
+}
@@ -0,0 +1,97 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const procNetDev = `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo: 191966928   21201    0    0    0     0          0         0 191966928   21201    0    0    0     0       0          0
+  eth0: 28812     294    1    2    3     4          5         6    35335     356    7    8    9     10       11          12
+`
+
+func TestParseProcNetDev(t *testing.T) {
+	counters, err := parseProcNetDev(strings.NewReader(procNetDev))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := NICCounters{RxBytes: 28812, RxPackets: 294, RxErrors: 1, RxDropped: 2, RxFifo: 3, RxFrame: 4, RxCompressed: 5, RxMulticast: 6,
+		TxBytes: 35335, TxPackets: 356, TxErrors: 7, TxDropped: 8, TxFifo: 9, TxCollisions: 10, TxCarrier: 11, TxCompressed: 12}
+	if len(counters) != 2 || counters["eth0"] != want || counters["lo"].RxBytes != 191966928 {
+		t.Errorf("parseProcNetDev() = %+v", counters)
+	}
+	if _, err = parseProcNetDev(strings.NewReader(procNetDev + "  eth1: 1 2 3\n")); err == nil {
+		t.Error("parseProcNetDev() of a short line should fail")
+	}
+}
+
+func TestReadNICInfo(t *testing.T) {
+	dir := t.TempDir()
+	eth := filepath.Join(dir, "eth0")
+	for _, d := range []string{"queues/rx-0", "queues/rx-1", "queues/tx-0", "device"} {
+		if err := os.MkdirAll(filepath.Join(eth, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{"speed": "10000\n", "duplex": "full\n", "operstate": "up\n", "tx_queue_len": "1000\n", "carrier_changes": "4\n"}
+	for name, v := range files {
+		if err := os.WriteFile(filepath.Join(eth, name), []byte(v), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("../../../bus/pci/drivers/ixgbe", filepath.Join(eth, "device", "driver")); err != nil {
+		t.Fatal(err)
+	}
+	got := readNICInfo(dir, net.Interface{Name: "eth0", Index: 2, MTU: 9000})
+	want := NICInfo{Name: "eth0", Index: 2, MTU: 9000, Speed: 10000, Duplex: "full", Driver: "ixgbe", OperState: "up",
+		RxQueues: 2, TxQueues: 1, TxQueueLen: 1000, CarrierChanges: 4}
+	if got != want {
+		t.Errorf("readNICInfo() = %+v, want %+v", got, want)
+	}
+
+	// a virtual interface without speed (EINVAL)
+	got = readNICInfo(dir, net.Interface{Name: "veth0", Index: 3, MTU: 1500})
+	if got.Speed != -1 || got.Duplex != "unknown" || got.Driver != "" {
+		t.Errorf("readNICInfo() of a virtual interface = %+v", got)
+	}
+}
+
+func TestTakeNICSnapshot(t *testing.T) {
+	lo := loopback(t)
+	before, err := TakeNICSnapshot([]string{lo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	c, err := net.Dial("udp4", ln.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for range 10 {
+		c.Write(make([]byte, 1000))
+	}
+	after, err := TakeNICSnapshot([]string{lo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := after.Delta(before)
+	if len(d) != 1 || d[0].Counters.TxPackets < 10 || d[0].Counters.TxBytes < 10000 || d[0].Duration <= 0 {
+		t.Errorf("Delta() = %v", d)
+	}
+	if _, err = TakeNICSnapshot([]string{"nspeed-none"}); err == nil {
+		t.Error("TakeNICSnapshot() of an unknown interface should fail")
+	}
+}
@@ -0,0 +1,21 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !linux
+
+package network
+
+import (
+	"errors"
+)
+
+// GetNICInfo is only supported on Linux
+func GetNICInfo(names []string) ([]NICInfo, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// GetNICCounters is only supported on Linux
+func GetNICCounters(names []string) (map[string]NICCounters, error) {
+	return nil, errors.ErrUnsupported
+}
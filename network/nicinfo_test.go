@@ -0,0 +1,39 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNICSnapshotDelta(t *testing.T) {
+	start := time.Now()
+	before := NICSnapshot{Time: start, Interfaces: []NICInfo{
+		{Name: "eth0", CarrierChanges: 2, Counters: NICCounters{RxBytes: 1000, RxPackets: 10, RxDropped: 1, TxBytes: 500}},
+		{Name: "eth1", Counters: NICCounters{RxBytes: 5000}},
+		{Name: "gone"},
+	}}
+	after := NICSnapshot{Time: start.Add(2 * time.Second), Interfaces: []NICInfo{
+		{Name: "eth0", CarrierChanges: 3, Counters: NICCounters{RxBytes: 3000, RxPackets: 30, RxDropped: 4, TxBytes: 700}},
+		{Name: "eth1", Counters: NICCounters{RxBytes: 100}}, // reset
+		{Name: "new"},
+	}}
+	d := after.Delta(before)
+	if len(d) != 2 {
+		t.Fatalf("Delta() = %v", d)
+	}
+	want := NICDelta{Name: "eth0", Duration: 2 * time.Second, CarrierChanges: 1,
+		Counters: NICCounters{RxBytes: 2000, RxPackets: 20, RxDropped: 3, TxBytes: 200}}
+	if d[0] != want {
+		t.Errorf("Delta() = %+v, want %+v", d[0], want)
+	}
+	if d[0].Counters.Problems() != 3 {
+		t.Errorf("Problems() = %d, want 3", d[0].Counters.Problems())
+	}
+	if d[1].Name != "eth1" || d[1].Counters.RxBytes != 100 {
+		t.Errorf("Delta() of a reset counter = %+v", d[1])
+	}
+}
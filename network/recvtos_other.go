@@ -0,0 +1,23 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !linux && !darwin && !freebsd
+
+package network
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// SetReceiveTrafficClass is not supported on this platform, the error wraps errors.ErrUnsupported
+func SetReceiveTrafficClass(rc syscall.RawConn, network string) error {
+	return fmt.Errorf("receive traffic class: %w on this platform", errors.ErrUnsupported)
+}
+
+// ParseTrafficClass is not supported on this platform, ok is always false
+func ParseTrafficClass(oob []byte) (tc TrafficClass, ok bool) {
+	return 0, false
+}
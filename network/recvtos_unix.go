@@ -0,0 +1,62 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux || darwin || freebsd
+
+package network
+
+import (
+	"encoding/binary"
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// SetReceiveTrafficClass enables the reception of the traffic class of the packets received
+// by a socket of network (IP_RECVTOS or IPV6_RECVTCLASS) as control messages, see ParseTrafficClass.
+func SetReceiveTrafficClass(rc syscall.RawConn, network string) error {
+	isIPv4 := isIPv4Network(network)
+	var err error
+	cerr := rc.Control(func(fd uintptr) {
+		if isIPv4 {
+			err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_RECVTOS, 1)
+			return
+		}
+		err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_RECVTCLASS, 1)
+		if err == nil {
+			// dual stack socket, not an error if not supported
+			_ = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_RECVTOS, 1)
+		}
+	})
+	if cerr != nil {
+		return cerr
+	}
+	if err != nil {
+		return fmt.Errorf("error setting receive traffic class: %w", err)
+	}
+	return nil
+}
+
+// ParseTrafficClass returns the traffic class found in the control messages oob
+// (as returned by net.UDPConn.ReadMsgUDP or net.IPConn.ReadMsgIP), ok is false if there is none.
+func ParseTrafficClass(oob []byte) (tc TrafficClass, ok bool) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0, false
+	}
+	for _, m := range msgs {
+		switch {
+		case m.Header.Level == unix.IPPROTO_IP && (m.Header.Type == unix.IP_TOS || m.Header.Type == unix.IP_RECVTOS):
+			if len(m.Data) >= 1 {
+				return TrafficClass(m.Data[0]), true
+			}
+		case m.Header.Level == unix.IPPROTO_IPV6 && m.Header.Type == unix.IPV6_TCLASS:
+			if len(m.Data) >= 4 {
+				return TrafficClass(binary.NativeEndian.Uint32(m.Data)), true
+			}
+		}
+	}
+	return 0, false
+}
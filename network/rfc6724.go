@@ -0,0 +1,297 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"cmp"
+	"net/netip"
+	"slices"
+)
+
+// RFC 6724 address selection: source address selection (section 5)
+// and destination address ordering (section 6), with the default policy table.
+
+// SourceAddress is a candidate source address of the host, see GetSourceAddresses
+type SourceAddress struct {
+	Prefix     netip.Prefix // the address and the prefix length of its subnet
+	Interface  string       // name of the interface of the address
+	Deprecated bool         // the preferred lifetime of the address is over
+	Temporary  bool         // privacy address (RFC 8981)
+}
+
+// Addr returns the address of s
+func (s SourceAddress) Addr() netip.Addr {
+	return s.Prefix.Addr()
+}
+
+type policy struct {
+	prefix     netip.Prefix
+	precedence int
+	label      int
+}
+
+// RFC 6724 section 2.1, longest prefixes first. IPv4 addresses are looked up as ::ffff:a.b.c.d
+var policyTable = []policy{
+	{netip.MustParsePrefix("::1/128"), 50, 0},
+	{netip.MustParsePrefix("::ffff:0:0/96"), 35, 4},
+	{netip.MustParsePrefix("::/96"), 1, 3},
+	{netip.MustParsePrefix("2001::/32"), 5, 5},
+	{netip.MustParsePrefix("2002::/16"), 30, 2},
+	{netip.MustParsePrefix("3ffe::/16"), 1, 12},
+	{netip.MustParsePrefix("fec0::/10"), 1, 11},
+	{netip.MustParsePrefix("fc00::/7"), 3, 13},
+	{netip.MustParsePrefix("::/0"), 40, 1},
+}
+
+func classify(a netip.Addr) policy {
+	a16 := netip.AddrFrom16(a.As16())
+	for _, p := range policyTable {
+		if p.prefix.Contains(a16) {
+			return p
+		}
+	}
+	return policy{}
+}
+
+// address scopes (RFC 4291 multicast scopes)
+const (
+	scopeLinkLocal = 0x2
+	scopeSiteLocal = 0x5
+	scopeGlobal    = 0xe
+)
+
+// scope returns the RFC 6724 scope of an address
+func scope(a netip.Addr) int {
+	if a.Is4() || a.Is4In6() {
+		a = a.Unmap()
+		// RFC 6724 section 3.2: loopback and auto-configuration addresses are link-local, private ones are global
+		if a.IsLoopback() || a.IsLinkLocalUnicast() || a.IsLinkLocalMulticast() {
+			return scopeLinkLocal
+		}
+		return scopeGlobal
+	}
+	switch {
+	case a.IsMulticast():
+		return int(a.As16()[1] & 0x0f)
+	case a.IsLoopback(), a.IsLinkLocalUnicast():
+		return scopeLinkLocal
+	case netip.MustParsePrefix("fec0::/10").Contains(a):
+		return scopeSiteLocal
+	default:
+		return scopeGlobal
+	}
+}
+
+// commonPrefixLen returns the number of leading bits shared by source and destination,
+// up to the prefix length of the source subnet (64 at most for IPv6, the interface identifier is ignored).
+func commonPrefixLen(source netip.Prefix, destination netip.Addr) int {
+	s, d := source.Addr().Unmap(), destination.Unmap()
+	if s.Is4() != d.Is4() {
+		return 0
+	}
+	limit := source.Bits()
+	if s.Is6() {
+		limit = min(limit, 64)
+	}
+	n := 0
+	sb, db := s.AsSlice(), d.AsSlice()
+	for i := range sb {
+		x := sb[i] ^ db[i]
+		if x == 0 {
+			n += 8
+			continue
+		}
+		for x&0x80 == 0 {
+			n++
+			x <<= 1
+		}
+		break
+	}
+	if limit >= 0 && n > limit {
+		n = limit
+	}
+	return n
+}
+
+// compareSources returns -1 if a is a better source than b for destination, 1 if b is better, 0 if equal.
+func compareSources(destination netip.Addr, a, b SourceAddress) int {
+	sa, sb := a.Addr().Unmap(), b.Addr().Unmap()
+	d := destination.Unmap()
+	// rule 1: prefer same address
+	if sa == d {
+		return -1
+	}
+	if sb == d {
+		return 1
+	}
+	// rule 2: prefer appropriate scope
+	scopeA, scopeB, scopeD := scope(sa), scope(sb), scope(d)
+	if scopeA < scopeB {
+		if scopeA < scopeD {
+			return 1
+		}
+		return -1
+	}
+	if scopeB < scopeA {
+		if scopeB < scopeD {
+			return -1
+		}
+		return 1
+	}
+	// rule 3: avoid deprecated addresses
+	if a.Deprecated != b.Deprecated {
+		if a.Deprecated {
+			return 1
+		}
+		return -1
+	}
+	// rules 4 (home addresses), 5 (outgoing interface) and 5.5 (next-hop prefix) are not applicable:
+	// the candidates are the ones of the outgoing interface
+	// rule 6: prefer matching label
+	labelD := classify(d).label
+	if matchA, matchB := classify(sa).label == labelD, classify(sb).label == labelD; matchA != matchB {
+		if matchA {
+			return -1
+		}
+		return 1
+	}
+	// rule 7: prefer temporary addresses
+	if a.Temporary != b.Temporary {
+		if a.Temporary {
+			return -1
+		}
+		return 1
+	}
+	// rule 8: use longest matching prefix
+	return cmp.Compare(commonPrefixLen(b.Prefix, d), commonPrefixLen(a.Prefix, d))
+}
+
+// SelectSource returns the RFC 6724 source address for destination among candidates,
+// false if no candidate has the IP version of destination.
+func SelectSource(destination netip.Addr, candidates []SourceAddress) (SourceAddress, bool) {
+	var best SourceAddress
+	found := false
+	for _, c := range candidates {
+		if c.Addr().Unmap().Is4() != destination.Unmap().Is4() {
+			continue
+		}
+		if !found || compareSources(destination, c, best) < 0 {
+			best, found = c, true
+		}
+	}
+	return best, found
+}
+
+// preferredSource returns the preferred source address among candidates of ipVersion (0 for any) without a destination:
+// larger scope (rule 2), not deprecated (rule 3), higher precedence (destination rule 6), temporary (rule 7),
+// the first one if equivalent. False if there's no candidate of ipVersion.
+func preferredSource(candidates []SourceAddress, ipVersion IPVersion) (SourceAddress, bool) {
+	var best SourceAddress
+	found := false
+	for _, c := range candidates {
+		if ipVersion != 0 && GetIPVersion(c.Addr().Unmap()) != ipVersion {
+			continue
+		}
+		if !found || comparePreferredSources(c, best) < 0 {
+			best, found = c, true
+		}
+	}
+	return best, found
+}
+
+// comparePreferredSources returns -1 if a is preferred to b without a destination, 1 if b is preferred, 0 if equal.
+func comparePreferredSources(a, b SourceAddress) int {
+	sa, sb := a.Addr().Unmap(), b.Addr().Unmap()
+	if c := cmp.Compare(scope(sb), scope(sa)); c != 0 {
+		return c
+	}
+	if a.Deprecated != b.Deprecated {
+		if a.Deprecated {
+			return 1
+		}
+		return -1
+	}
+	if c := cmp.Compare(classify(sb).precedence, classify(sa).precedence); c != 0 {
+		return c
+	}
+	if a.Temporary != b.Temporary {
+		if a.Temporary {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+// SortDestinations sorts destinations by RFC 6724 preference, the source of each destination
+// is selected among sources (see SelectSource). The sort is stable:
+// equivalent destinations keep their order (DNS round robin).
+func SortDestinations(destinations []netip.Addr, sources []SourceAddress) {
+	type destination struct {
+		orig   netip.Addr
+		addr   netip.Addr // unmapped
+		source SourceAddress
+		usable bool
+	}
+	ds := make([]destination, len(destinations))
+	for i, d := range destinations {
+		ds[i].orig, ds[i].addr = d, d.Unmap()
+		ds[i].source, ds[i].usable = SelectSource(d, sources)
+	}
+	slices.SortStableFunc(ds, func(a, b destination) int {
+		// rule 1: avoid unusable destinations
+		if a.usable != b.usable {
+			if a.usable {
+				return -1
+			}
+			return 1
+		}
+		if !a.usable {
+			return 0
+		}
+		sa, sb := a.source.Addr().Unmap(), b.source.Addr().Unmap()
+		// rule 2: prefer matching scope
+		if matchA, matchB := scope(a.addr) == scope(sa), scope(b.addr) == scope(sb); matchA != matchB {
+			if matchA {
+				return -1
+			}
+			return 1
+		}
+		// rule 3: avoid deprecated addresses
+		if a.source.Deprecated != b.source.Deprecated {
+			if a.source.Deprecated {
+				return 1
+			}
+			return -1
+		}
+		// rule 4 (home addresses) is not applicable
+		// rule 5: prefer matching label
+		pa, pb := classify(a.addr), classify(b.addr)
+		if matchA, matchB := classify(sa).label == pa.label, classify(sb).label == pb.label; matchA != matchB {
+			if matchA {
+				return -1
+			}
+			return 1
+		}
+		// rule 6: prefer higher precedence
+		if pa.precedence != pb.precedence {
+			return cmp.Compare(pb.precedence, pa.precedence)
+		}
+		// rule 7 (native transport) is not applicable
+		// rule 8: prefer smaller scope
+		if c := cmp.Compare(scope(a.addr), scope(b.addr)); c != 0 {
+			return c
+		}
+		// rule 9: use longest matching prefix, like Go only for IPv6 to keep the IPv4 DNS round robin
+		if a.addr.Is6() && b.addr.Is6() {
+			return cmp.Compare(commonPrefixLen(b.source.Prefix, b.addr), commonPrefixLen(a.source.Prefix, a.addr))
+		}
+		// rule 10: leave the order unchanged
+		return 0
+	})
+	for i := range ds {
+		destinations[i] = ds[i].orig
+	}
+}
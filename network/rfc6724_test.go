@@ -0,0 +1,186 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// sources parses "address[/bits][ flags]" candidates, flags are "deprecated" and "temporary"
+func sources(s ...string) []SourceAddress {
+	var r []SourceAddress
+	for _, v := range s {
+		f := strings.Fields(v)
+		p, err := netip.ParsePrefix(f[0])
+		if err != nil {
+			a := netip.MustParseAddr(f[0])
+			bits := 64
+			if a.Is4() {
+				bits = 24
+			}
+			p = netip.PrefixFrom(a, bits)
+		}
+		r = append(r, SourceAddress{Prefix: p, Deprecated: slices.Contains(f, "deprecated"), Temporary: slices.Contains(f, "temporary")})
+	}
+	return r
+}
+
+// examples of RFC 6724 section 10.1
+func TestSelectSource(t *testing.T) {
+	tests := []struct {
+		name        string
+		destination string
+		candidates  []SourceAddress
+		want        string
+	}{
+		{"rule 2 global", "2001:db8:1::1", sources("fe80::1", "2001:db8:3::1"), "2001:db8:3::1"},
+		{"rule 2 multicast", "ff05::1", sources("fe80::1", "2001:db8:3::1"), "2001:db8:3::1"},
+		{"rule 1", "2001:db8:1::1", sources("2001:db8:2::1", "2001:db8:1::1"), "2001:db8:1::1"},
+		{"rule 2 link-local", "fe80::1", sources("2001:db8:1::1", "fe80::2"), "fe80::2"},
+		{"rule 8", "2001:db8:1::1", sources("2001:db8:3::2", "2001:db8:1::2"), "2001:db8:1::2"},
+		{"rule 6", "2002:c633:6401::1", sources("2001:db8:1::2", "2002:c633:6401::d5e3:7953:13eb:22e8 temporary"), "2002:c633:6401::d5e3:7953:13eb:22e8"},
+		{"rule 7", "2001:db8:1::d5e3:0:0:1", sources("2001:db8:1::2", "2001:db8:1::d5e3:7953:13eb:22e8 temporary"), "2001:db8:1::d5e3:7953:13eb:22e8"},
+		{"rule 3", "2001:db8:1::1", sources("2001:db8:1::2 deprecated", "2001:db8:3::2"), "2001:db8:3::2"},
+		{"ipv4 private", "8.8.8.8", sources("fe80::1", "169.254.1.1", "192.168.1.10"), "192.168.1.10"},
+		{"ipv4 mapped", "::ffff:192.168.1.1", sources("2001:db8:1::2", "192.168.1.10"), "192.168.1.10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := SelectSource(netip.MustParseAddr(tt.destination), tt.candidates)
+			if !ok || got.Addr() != netip.MustParseAddr(tt.want) {
+				t.Errorf("SelectSource() = %s, %v want %s", got.Addr(), ok, tt.want)
+			}
+		})
+	}
+	if _, ok := SelectSource(netip.MustParseAddr("8.8.8.8"), sources("2001:db8:1::2")); ok {
+		t.Error("SelectSource() without an IPv4 candidate should fail")
+	}
+}
+
+// examples of RFC 6724 section 10.2
+func TestSortDestinations(t *testing.T) {
+	tests := []struct {
+		name         string
+		destinations []string
+		sources      []SourceAddress
+		want         []string
+	}{
+		{"rule 2 ipv6", []string{"198.51.100.121", "2001:db8:1::1"}, sources("2001:db8:1::2", "fe80::1", "169.254.13.78"),
+			[]string{"2001:db8:1::1", "198.51.100.121"}},
+		{"rule 2 ipv4", []string{"2001:db8:1::1", "198.51.100.121"}, sources("fe80::1", "198.51.100.117"),
+			[]string{"198.51.100.121", "2001:db8:1::1"}},
+		{"rule 6", []string{"10.1.2.3", "2001:db8:1::1"}, sources("2001:db8:1::2", "fe80::1", "10.1.2.4"),
+			[]string{"2001:db8:1::1", "10.1.2.3"}},
+		{"rule 8", []string{"2001:db8:1::1", "fe80::1"}, sources("2001:db8:1::2", "fe80::2"),
+			[]string{"fe80::1", "2001:db8:1::1"}},
+		{"rule 9", []string{"2001:db8:3ffe::1", "2001:db8:1::1"}, sources("2001:db8:1::2", "2001:db8:3f44::2", "fe80::2"),
+			[]string{"2001:db8:1::1", "2001:db8:3ffe::1"}},
+		{"rule 5", []string{"2001:db8:1::1", "2002:c633:6401::1"}, sources("2002:c633:6401::2", "fe80::2"),
+			[]string{"2002:c633:6401::1", "2001:db8:1::1"}},
+		{"rule 6 6to4", []string{"2002:c633:6401::1", "2001:db8:1::1"}, sources("2002:c633:6401::2", "2001:db8:1::2", "fe80::2"),
+			[]string{"2001:db8:1::1", "2002:c633:6401::1"}},
+		{"rule 1", []string{"2001:db8:1::1", "192.0.2.1"}, sources("192.0.2.2"),
+			[]string{"192.0.2.1", "2001:db8:1::1"}},
+		{"rule 10 ipv4 order kept", []string{"192.0.2.20", "192.0.2.10"}, sources("192.0.2.1"),
+			[]string{"192.0.2.20", "192.0.2.10"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var destinations []netip.Addr
+			for _, d := range tt.destinations {
+				destinations = append(destinations, netip.MustParseAddr(d))
+			}
+			SortDestinations(destinations, tt.sources)
+			var got []string
+			for _, d := range destinations {
+				got = append(got, d.String())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("SortDestinations() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreferredSource(t *testing.T) {
+	tests := []struct {
+		name       string
+		ipVersion  IPVersion
+		candidates []SourceAddress
+		want       string
+	}{
+		{"scope", 0, sources("fe80::1", "169.254.1.1", "192.168.1.10"), "192.168.1.10"},
+		{"scope ipv6", 6, sources("fe80::1", "192.168.1.10", "2001:db8:1::1"), "2001:db8:1::1"},
+		{"deprecated", 0, sources("2001:db8:1::1 deprecated", "192.168.1.10"), "192.168.1.10"},
+		{"precedence", 0, sources("192.168.1.10", "2001:db8:1::1"), "2001:db8:1::1"},
+		{"precedence ula", 0, sources("fd00::1", "192.168.1.10"), "192.168.1.10"},
+		{"temporary", 0, sources("2001:db8:1::1", "2001:db8:1::d5e3:7953:13eb:22e8 temporary"), "2001:db8:1::d5e3:7953:13eb:22e8"},
+		{"first", 0, sources("2001:db8:1::1", "2001:db8:2::1"), "2001:db8:1::1"},
+		{"ipv4", 4, sources("2001:db8:1::1", "fe80::1", "192.168.1.10"), "192.168.1.10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := preferredSource(tt.candidates, tt.ipVersion)
+			if !ok || got.Addr() != netip.MustParseAddr(tt.want) {
+				t.Errorf("preferredSource() = %s, %v want %s", got.Addr(), ok, tt.want)
+			}
+		})
+	}
+	if _, ok := preferredSource(sources("2001:db8:1::2"), 4); ok {
+		t.Error("preferredSource() without a candidate of the version should fail")
+	}
+}
+
+func TestInterfaceAddressFor(t *testing.T) {
+	lo := "lo"
+	if _, err := GetSourceAddresses(lo); err != nil {
+		t.Skip(err)
+	}
+	a, err := InterfaceAddressFor(lo, 0, netip.MustParseAddr("127.0.0.2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.IP.String() != "127.0.0.1" {
+		t.Errorf("InterfaceAddressFor() = %s, want 127.0.0.1", a)
+	}
+	if _, err = InterfaceAddressFor(lo, 6, netip.MustParseAddr("127.0.0.2")); err == nil {
+		t.Error("InterfaceAddressFor() with a version mismatch should fail")
+	}
+	if a, err = InterfaceAddress(lo, 4); err != nil || a.IP.String() != "127.0.0.1" {
+		t.Errorf("InterfaceAddress() = %s, %v want 127.0.0.1", a, err)
+	}
+
+	// a host address, not an interface name
+	if a, err = InterfaceAddressFor("127.0.0.1", 0, netip.MustParseAddr("127.0.0.2")); err != nil || a.IP.String() != "127.0.0.1" {
+		t.Errorf("InterfaceAddressFor() host = %s, %v want 127.0.0.1", a, err)
+	}
+	if _, err = InterfaceAddressFor("127.0.0.1", 0, netip.MustParseAddr("::1")); err == nil {
+		t.Error("InterfaceAddressFor() host without an address of the destination version should fail")
+	}
+	if a, err = InterfaceAddress("127.0.0.1", 4); err != nil || a.IP.String() != "127.0.0.1" {
+		t.Errorf("InterfaceAddress() host = %s, %v want 127.0.0.1", a, err)
+	}
+	if a, err = InterfaceAddress("fe80::1%"+lo, 0); err != nil || a.String() != "fe80::1%"+lo {
+		t.Errorf("InterfaceAddress() link-local host = %s, %v want fe80::1%%%s", a, err, lo)
+	}
+
+	// a host name with several addresses: the longest matching prefix, whatever the resolution order
+	prefer, dial := net.DefaultResolver.PreferGo, net.DefaultResolver.Dial
+	t.Cleanup(func() { net.DefaultResolver.PreferGo, net.DefaultResolver.Dial = prefer, dial })
+	r := testResolver([]netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("198.51.100.1")}, 0)
+	net.DefaultResolver.PreferGo, net.DefaultResolver.Dial = r.PreferGo, r.Dial
+	for _, tt := range []struct{ destination, want string }{
+		{"192.0.2.200", "192.0.2.1"},
+		{"198.51.100.200", "198.51.100.1"},
+	} {
+		if a, err = InterfaceAddressFor("multi.nspeed.test", 0, netip.MustParseAddr(tt.destination)); err != nil || a.IP.String() != tt.want {
+			t.Errorf("InterfaceAddressFor() host name for %s = %s, %v want %s", tt.destination, a, err, tt.want)
+		}
+	}
+}
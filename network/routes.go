@@ -0,0 +1,153 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// routing tables (Linux)
+const (
+	RouteTableAll     = 0   // all the tables (GetRoutes)
+	RouteTableDefault = 253 // "default" table
+	RouteTableMain    = 254 // "main" table, the one of 'ip route show'
+	RouteTableLocal   = 255 // "local" table: local and broadcast addresses
+)
+
+// Route is an entry of the routing table
+type Route struct {
+	Version     IPVersion
+	Type        int          // route type: 1 = unicast, 2 = local, 6 = blackhole, 7 = unreachable ... see RouteTypeName
+	Destination netip.Prefix // destination prefix, 0.0.0.0/0 or ::/0 for the default route
+	Gateway     netip.Addr   // next hop, invalid for a direct route
+	Interface   string       // name of the outgoing interface
+	Index       int          // index of the outgoing interface, 0 if none
+	Source      netip.Addr   // preferred source address, invalid if none
+	Metric      int          // priority of the route, lower is preferred
+	Table       int          // routing table, see RouteTableMain
+	Protocol    int          // origin of the route: 2 = kernel, 3 = boot, 4 = static, 16 = dhcp ... see RouteProtocolName
+	Scope       int          // scope of the destination: 0 = universe (global), 253 = link, 254 = host
+	NextHops    []NextHop    // next hops of a multipath route
+}
+
+// NextHop is a next hop of a multipath route
+type NextHop struct {
+	Gateway   netip.Addr
+	Interface string
+	Index     int
+	Weight    int // weight of the next hop (1-256)
+}
+
+// same names as iproute2 (/etc/iproute2/rt_protos)
+var routeProtocolNames = map[int]string{
+	0: "unspec", 1: "redirect", 2: "kernel", 3: "boot", 4: "static", 8: "gated", 9: "ra", 10: "mrt",
+	11: "zebra", 12: "bird", 13: "dnrouted", 14: "xorp", 15: "ntk", 16: "dhcp", 17: "mrouted",
+	18: "keepalived", 42: "babel", 99: "openr", 186: "bgp", 187: "isis", 188: "ospf", 189: "rip", 192: "eigrp",
+}
+
+var routeTypeNames = map[int]string{
+	0: "unspec", 1: "unicast", 2: "local", 3: "broadcast", 4: "anycast", 5: "multicast",
+	6: "blackhole", 7: "unreachable", 8: "prohibit", 9: "throw", 10: "nat",
+}
+
+var routeScopeNames = map[int]string{
+	0: "global", 200: "site", 253: "link", 254: "host", 255: "nowhere",
+}
+
+var routeTableNames = map[int]string{
+	RouteTableDefault: "default", RouteTableMain: "main", RouteTableLocal: "local",
+}
+
+// nameOr returns the name of v in names or its numeric value
+func nameOr(names map[int]string, v int) string {
+	if n, ok := names[v]; ok {
+		return n
+	}
+	return strconv.Itoa(v)
+}
+
+// RouteProtocolName returns the name of a route protocol ("kernel", "dhcp", ...)
+func RouteProtocolName(protocol int) string {
+	return nameOr(routeProtocolNames, protocol)
+}
+
+// RouteTypeName returns the name of a route type ("unicast", "local", ...)
+func RouteTypeName(typ int) string {
+	return nameOr(routeTypeNames, typ)
+}
+
+// RouteTableName returns the name of a routing table ("main", "local", ...)
+func RouteTableName(table int) string {
+	return nameOr(routeTableNames, table)
+}
+
+// ParseRouteTable parses a routing table name ("main", "local", "default", "all") or number
+func ParseRouteTable(s string) (int, error) {
+	if s == "all" {
+		return RouteTableAll, nil
+	}
+	for v, n := range routeTableNames {
+		if n == s {
+			return v, nil
+		}
+	}
+	v, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid routing table: %s", s)
+	}
+	return int(v), nil
+}
+
+// String returns the route in the 'ip route show' format
+func (r Route) String() string {
+	var b strings.Builder
+	if r.Type != 1 {
+		b.WriteString(RouteTypeName(r.Type) + " ")
+	}
+	switch {
+	case r.Destination.Bits() == 0:
+		b.WriteString("default")
+	case r.Destination.IsSingleIP():
+		b.WriteString(r.Destination.Addr().String())
+	default:
+		b.WriteString(r.Destination.String())
+	}
+	if r.Gateway.IsValid() {
+		fmt.Fprintf(&b, " via %s", r.Gateway)
+	}
+	if r.Interface != "" {
+		fmt.Fprintf(&b, " dev %s", r.Interface)
+	}
+	if r.Table != RouteTableMain && r.Table != 0 {
+		fmt.Fprintf(&b, " table %s", RouteTableName(r.Table))
+	}
+	// unspec is the protocol of the route lookups replies
+	if r.Protocol != 3 && r.Protocol != 0 {
+		fmt.Fprintf(&b, " proto %s", RouteProtocolName(r.Protocol))
+	}
+	if r.Scope != 0 {
+		fmt.Fprintf(&b, " scope %s", nameOr(routeScopeNames, r.Scope))
+	}
+	if r.Source.IsValid() {
+		fmt.Fprintf(&b, " src %s", r.Source)
+	}
+	if r.Metric != 0 {
+		fmt.Fprintf(&b, " metric %d", r.Metric)
+	}
+	for _, h := range r.NextHops {
+		b.WriteString("\n\tnexthop")
+		if h.Gateway.IsValid() {
+			fmt.Fprintf(&b, " via %s", h.Gateway)
+		}
+		if h.Interface != "" {
+			fmt.Fprintf(&b, " dev %s", h.Interface)
+		}
+		fmt.Fprintf(&b, " weight %d", h.Weight)
+	}
+	return b.String()
+}
@@ -0,0 +1,272 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// GetRoutes returns the routes of a routing table (RouteTableMain, RouteTableAll, ...)
+// for an IP version (0 for both), in the kernel order.
+func GetRoutes(ipVersion IPVersion, table int) ([]Route, error) {
+	var families []int
+	switch ipVersion {
+	case 4:
+		families = []int{syscall.AF_INET}
+	case 6:
+		families = []int{syscall.AF_INET6}
+	default:
+		families = []int{syscall.AF_INET, syscall.AF_INET6}
+	}
+	names := make(map[int]string)
+	var routes []Route
+	for _, family := range families {
+		rib, err := syscall.NetlinkRIB(syscall.RTM_GETROUTE, family)
+		if err != nil {
+			return nil, fmt.Errorf("netlink route dump error: %w", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(rib)
+		if err != nil {
+			return nil, fmt.Errorf("netlink parse error: %w", err)
+		}
+		for i := range msgs {
+			if msgs[i].Header.Type != syscall.RTM_NEWROUTE {
+				continue
+			}
+			r, err := parseRouteMessage(&msgs[i])
+			if err != nil {
+				return nil, err
+			}
+			if table != RouteTableAll && r.Table != table {
+				continue
+			}
+			r.Interface = interfaceName(names, r.Index)
+			for k := range r.NextHops {
+				r.NextHops[k].Interface = interfaceName(names, r.NextHops[k].Index)
+			}
+			routes = append(routes, r)
+		}
+	}
+	return routes, nil
+}
+
+// lookupRoute asks the kernel the route to destination with the options (RTM_GETROUTE request),
+// like "ip route get destination from source oif interface mark mark"
+func lookupRoute(destination netip.Addr, options RouteOptions) (Route, error) {
+	family, bits := syscall.AF_INET6, 128
+	if destination.Is4() {
+		family, bits = syscall.AF_INET, 32
+	}
+	// struct rtmsg then the attributes
+	req := []byte{byte(family), byte(bits), 0, 0, 0, 0, 0, 0}
+	req = binary.NativeEndian.AppendUint32(req, unix.RTM_F_LOOKUP_TABLE)
+	req = appendRouteAttr(req, syscall.RTA_DST, destination.AsSlice())
+	if options.Source.IsValid() {
+		src := options.Source.Unmap()
+		if src.Is4() != destination.Is4() {
+			return Route{}, fmt.Errorf("source %s and destination %s are not the same IP version", src, destination)
+		}
+		req[2] = byte(bits)
+		req = appendRouteAttr(req, syscall.RTA_SRC, src.AsSlice())
+	}
+	if options.Interface != "" {
+		iface, err := net.InterfaceByName(options.Interface)
+		if err != nil {
+			return Route{}, fmt.Errorf("interface error: %w", err)
+		}
+		req = appendRouteAttr(req, syscall.RTA_OIF, binary.NativeEndian.AppendUint32(nil, uint32(iface.Index)))
+	}
+	if options.Mark != 0 {
+		req = appendRouteAttr(req, unix.RTA_MARK, binary.NativeEndian.AppendUint32(nil, options.Mark))
+	}
+
+	m, err := netlinkRequest(syscall.RTM_GETROUTE, req)
+	if err != nil {
+		return Route{}, err
+	}
+	if m.Header.Type != syscall.RTM_NEWROUTE {
+		return Route{}, fmt.Errorf("unexpected netlink message type: %d", m.Header.Type)
+	}
+	r, err := parseRouteMessage(m)
+	if err != nil {
+		return r, err
+	}
+	if !r.Source.IsValid() {
+		r.Source = options.Source
+	}
+	names := make(map[int]string)
+	r.Interface = interfaceName(names, r.Index)
+	return r, nil
+}
+
+// netlinkRequest sends a route netlink request and returns its reply
+func netlinkRequest(typ uint16, data []byte) (*syscall.NetlinkMessage, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("netlink socket error: %w", err)
+	}
+	defer unix.Close(fd)
+	sa := &unix.SockaddrNetlink{Family: unix.AF_NETLINK}
+	if err = unix.Bind(fd, sa); err != nil {
+		return nil, fmt.Errorf("netlink bind error: %w", err)
+	}
+	const seq = 1
+	// struct nlmsghdr: len (u32), type (u16), flags (u16), seq (u32), pid (u32)
+	b := binary.NativeEndian.AppendUint32(nil, uint32(syscall.NLMSG_HDRLEN+len(data)))
+	b = binary.NativeEndian.AppendUint16(b, typ)
+	b = binary.NativeEndian.AppendUint16(b, syscall.NLM_F_REQUEST)
+	b = binary.NativeEndian.AppendUint32(b, seq)
+	b = binary.NativeEndian.AppendUint32(b, 0)
+	if err = unix.Sendto(fd, append(b, data...), 0, sa); err != nil {
+		return nil, fmt.Errorf("netlink send error: %w", err)
+	}
+	rb := make([]byte, 16*1024)
+	for {
+		n, _, err := unix.Recvfrom(fd, rb, 0)
+		if err != nil {
+			return nil, fmt.Errorf("netlink receive error: %w", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(rb[:n])
+		if err != nil {
+			return nil, fmt.Errorf("netlink parse error: %w", err)
+		}
+		for i := range msgs {
+			m := &msgs[i]
+			if m.Header.Seq != seq {
+				continue
+			}
+			if m.Header.Type == syscall.NLMSG_ERROR {
+				// struct nlmsgerr: error (i32, -errno) then the request header
+				if len(m.Data) < 4 {
+					return nil, fmt.Errorf("netlink error message too short")
+				}
+				if errno := -int32(binary.NativeEndian.Uint32(m.Data[0:4])); errno != 0 {
+					return nil, syscall.Errno(errno)
+				}
+				continue
+			}
+			return m, nil
+		}
+	}
+}
+
+// appendRouteAttr appends a route attribute (struct rtattr and its aligned value) to b
+func appendRouteAttr(b []byte, typ uint16, value []byte) []byte {
+	l := syscall.SizeofRtAttr + len(value)
+	b = binary.NativeEndian.AppendUint16(b, uint16(l))
+	b = binary.NativeEndian.AppendUint16(b, typ)
+	b = append(b, value...)
+	return append(b, make([]byte, rtaAlign(l)-l)...)
+}
+
+// interfaceName returns the name of an interface index, cached in names
+func interfaceName(names map[int]string, index int) string {
+	if index == 0 {
+		return ""
+	}
+	if n, ok := names[index]; ok {
+		return n
+	}
+	n := fmt.Sprintf("if%d", index)
+	if iface, err := net.InterfaceByIndex(index); err == nil {
+		n = iface.Name
+	}
+	names[index] = n
+	return n
+}
+
+// parseRouteMessage decodes a RTM_NEWROUTE message (struct rtmsg and its attributes),
+// the interface names are not set.
+func parseRouteMessage(m *syscall.NetlinkMessage) (Route, error) {
+	var r Route
+	if len(m.Data) < syscall.SizeofRtMsg {
+		return r, fmt.Errorf("netlink route message too short: %d bytes", len(m.Data))
+	}
+	// struct rtmsg: family, dst_len, src_len, tos, table, protocol, scope, type, flags
+	family, dstLen := m.Data[0], int(m.Data[1])
+	r.Table = int(m.Data[4])
+	r.Protocol = int(m.Data[5])
+	r.Scope = int(m.Data[6])
+	r.Type = int(m.Data[7])
+	var unspecified netip.Addr
+	switch family {
+	case syscall.AF_INET:
+		r.Version, unspecified = 4, netip.IPv4Unspecified()
+	case syscall.AF_INET6:
+		r.Version, unspecified = 6, netip.IPv6Unspecified()
+	default:
+		return r, fmt.Errorf("unsupported route family: %d", family)
+	}
+	attrs, err := syscall.ParseNetlinkRouteAttr(m)
+	if err != nil {
+		return r, fmt.Errorf("netlink route attributes error: %w", err)
+	}
+	dst := unspecified
+	for _, a := range attrs {
+		switch a.Attr.Type {
+		case syscall.RTA_DST:
+			dst, _ = netip.AddrFromSlice(a.Value)
+		case syscall.RTA_GATEWAY:
+			r.Gateway, _ = netip.AddrFromSlice(a.Value)
+		case syscall.RTA_PREFSRC:
+			r.Source, _ = netip.AddrFromSlice(a.Value)
+		case syscall.RTA_OIF:
+			r.Index = int(nativeUint32(a.Value))
+		case syscall.RTA_PRIORITY:
+			r.Metric = int(nativeUint32(a.Value))
+		case syscall.RTA_TABLE:
+			r.Table = int(nativeUint32(a.Value))
+		case syscall.RTA_MULTIPATH:
+			r.NextHops = parseNextHops(a.Value)
+		}
+	}
+	if r.Destination, err = dst.Prefix(dstLen); err != nil {
+		return r, fmt.Errorf("invalid route destination: %w", err)
+	}
+	return r, nil
+}
+
+// parseNextHops decodes the struct rtnexthop list of a RTA_MULTIPATH attribute
+func parseNextHops(b []byte) []NextHop {
+	var hops []NextHop
+	for len(b) >= syscall.SizeofRtNexthop {
+		// struct rtnexthop: len (u16), flags (u8), hops (u8), ifindex (i32) then attributes
+		l := int(binary.NativeEndian.Uint16(b[0:2]))
+		if l < syscall.SizeofRtNexthop || l > len(b) {
+			break
+		}
+		h := NextHop{Weight: int(b[3]) + 1, Index: int(nativeUint32(b[4:8]))}
+		for a := b[syscall.SizeofRtNexthop:l]; len(a) >= syscall.SizeofRtAttr; {
+			al := int(binary.NativeEndian.Uint16(a[0:2]))
+			if al < syscall.SizeofRtAttr || al > len(a) {
+				break
+			}
+			if binary.NativeEndian.Uint16(a[2:4]) == syscall.RTA_GATEWAY {
+				h.Gateway, _ = netip.AddrFromSlice(a[syscall.SizeofRtAttr:al])
+			}
+			a = a[min(rtaAlign(al), len(a)):]
+		}
+		hops = append(hops, h)
+		b = b[min(rtaAlign(l), len(b)):]
+	}
+	return hops
+}
+
+func rtaAlign(l int) int {
+	return (l + syscall.RTA_ALIGNTO - 1) & ^(syscall.RTA_ALIGNTO - 1)
+}
+
+func nativeUint32(b []byte) uint32 {
+	if len(b) < 4 {
+		return 0
+	}
+	return binary.NativeEndian.Uint32(b)
+}
@@ -0,0 +1,134 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"syscall"
+	"testing"
+)
+
+// rtAttr encodes a route attribute
+func rtAttr(typ uint16, value []byte) []byte {
+	return appendRouteAttr(nil, typ, value)
+}
+
+func u32(v uint32) []byte {
+	return binary.NativeEndian.AppendUint32(nil, v)
+}
+
+func TestParseRouteMessage(t *testing.T) {
+	// 10.1.0.0/16 via 192.168.1.1 dev if2 proto static metric 100
+	data := []byte{syscall.AF_INET, 16, 0, 0, RouteTableMain, 4, 0, 1, 0, 0, 0, 0}
+	data = append(data, rtAttr(syscall.RTA_DST, []byte{10, 1, 0, 0})...)
+	data = append(data, rtAttr(syscall.RTA_GATEWAY, []byte{192, 168, 1, 1})...)
+	data = append(data, rtAttr(syscall.RTA_OIF, u32(2))...)
+	data = append(data, rtAttr(syscall.RTA_PRIORITY, u32(100))...)
+	r, err := parseRouteMessage(&syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: syscall.RTM_NEWROUTE}, Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Route{Version: 4, Type: 1, Destination: netip.MustParsePrefix("10.1.0.0/16"), Gateway: netip.MustParseAddr("192.168.1.1"),
+		Index: 2, Metric: 100, Table: RouteTableMain, Protocol: 4}
+	if r.String() != want.String() || r.Index != want.Index {
+		t.Errorf("parseRouteMessage() = %+v, want %+v", r, want)
+	}
+	r.Interface = "eth0"
+	if s := r.String(); s != "10.1.0.0/16 via 192.168.1.1 dev eth0 proto static metric 100" {
+		t.Errorf("String() = %q", s)
+	}
+
+	// IPv6 default route with 2 next hops in table 100
+	hop := func(index uint32, weight byte, gw string) []byte {
+		a := rtAttr(syscall.RTA_GATEWAY, netip.MustParseAddr(gw).AsSlice())
+		b := make([]byte, syscall.SizeofRtNexthop)
+		binary.NativeEndian.PutUint16(b[0:2], uint16(len(b)+len(a)))
+		b[3] = weight
+		binary.NativeEndian.PutUint32(b[4:8], index)
+		return append(b, a...)
+	}
+	data = []byte{syscall.AF_INET6, 0, 0, 0, 0, 3, 0, 1, 0, 0, 0, 0}
+	data = append(data, rtAttr(syscall.RTA_TABLE, u32(100))...)
+	data = append(data, rtAttr(syscall.RTA_MULTIPATH, append(hop(2, 0, "fe80::1"), hop(3, 1, "fe80::2")...))...)
+	r, err = parseRouteMessage(&syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: syscall.RTM_NEWROUTE}, Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.NextHops[0].Interface, r.NextHops[1].Interface = "eth0", "eth1"
+	if s := r.String(); s != "default table 100\n\tnexthop via fe80::1 dev eth0 weight 1\n\tnexthop via fe80::2 dev eth1 weight 2" {
+		t.Errorf("String() = %q", s)
+	}
+
+	if _, err = parseRouteMessage(&syscall.NetlinkMessage{Data: []byte{syscall.AF_INET}}); err == nil {
+		t.Error("parseRouteMessage() of a short message should fail")
+	}
+}
+
+func TestGetRoutes(t *testing.T) {
+	routes, err := GetRoutes(4, RouteTableLocal)
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, r := range routes {
+		if r.Table != RouteTableLocal {
+			t.Errorf("route %s is not in the local table", r)
+		}
+		if r.Type == 2 && r.Destination.Addr() == netip.MustParseAddr("127.0.0.1") {
+			if r.Interface == "" {
+				t.Errorf("route %s has no interface", r)
+			}
+			return
+		}
+	}
+	t.Errorf("no local route for 127.0.0.1 in %v", routes)
+}
+
+func TestParseRouteTable(t *testing.T) {
+	for s, want := range map[string]int{"main": RouteTableMain, "local": RouteTableLocal, "all": RouteTableAll, "100": 100} {
+		if got, err := ParseRouteTable(s); err != nil || got != want {
+			t.Errorf("ParseRouteTable(%q) = %d, %v want %d", s, got, err, want)
+		}
+	}
+	if _, err := ParseRouteTable("foo"); err == nil {
+		t.Error("ParseRouteTable(foo) should fail")
+	}
+}
+
+func TestGetRouteWithOptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		destination string
+		options     RouteOptions
+		wantErr     bool
+	}{
+		{"no options", "127.0.0.1", RouteOptions{}, false},
+		{"source", "127.0.0.1", RouteOptions{Source: netip.MustParseAddr("127.0.0.1")}, false},
+		{"interface", "127.0.0.1", RouteOptions{Interface: "lo"}, false},
+		{"mark", "127.0.0.1", RouteOptions{Mark: 42}, false},
+		{"ipv6", "::1", RouteOptions{Interface: "lo"}, false},
+		{"unknown interface", "127.0.0.1", RouteOptions{Interface: "nspeed-none"}, true},
+		{"version mismatch", "127.0.0.1", RouteOptions{Source: netip.MustParseAddr("::1")}, true},
+		{"invalid destination", "foo", RouteOptions{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := GetRouteWithOptions(tt.destination, tt.options)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("GetRouteWithOptions() = %s, want an error", r)
+				}
+				return
+			}
+			if err != nil {
+				t.Skip(err) // ipv6 may be disabled
+			}
+			// no table check: without rules the kernel merges the IPv4 local table in main
+			if r.Type != 2 || r.Interface != "lo" || r.Destination.Addr().String() != tt.destination {
+				t.Errorf("GetRouteWithOptions() = %s, want a local route on lo", r)
+			}
+		})
+	}
+}
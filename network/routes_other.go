@@ -0,0 +1,30 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !linux
+
+package network
+
+import (
+	"errors"
+	"net/netip"
+)
+
+// GetRoutes is not supported on this platform
+func GetRoutes(ipVersion IPVersion, table int) ([]Route, error) {
+	return nil, errors.New("routing table enumeration is not supported on this platform")
+}
+
+// lookupRoute falls back to GetRoute without options
+func lookupRoute(destination netip.Addr, options RouteOptions) (Route, error) {
+	if options != (RouteOptions{}) {
+		return Route{}, errors.New("route lookup with options is not supported on this platform")
+	}
+	iface, gw, src, err := GetRoute(destination.String())
+	if err != nil {
+		return Route{}, err
+	}
+	return Route{Version: GetIPVersion(destination), Type: 1, Destination: netip.PrefixFrom(destination, destination.BitLen()),
+		Gateway: gw, Interface: iface.Name, Index: iface.Index, Source: src}, nil
+}
@@ -0,0 +1,94 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// GetSourceAddresses returns the candidate source addresses of an interface (all interfaces if iface is empty)
+// with their deprecated and temporary flags. The tentative addresses (duplicate address detection) are not usable and skipped.
+func GetSourceAddresses(iface string) ([]SourceAddress, error) {
+	index := 0
+	if iface != "" {
+		itf, err := net.InterfaceByName(iface)
+		if err != nil {
+			return nil, err
+		}
+		index = itf.Index
+	}
+	rib, err := syscall.NetlinkRIB(syscall.RTM_GETADDR, syscall.AF_UNSPEC)
+	if err != nil {
+		return nil, fmt.Errorf("netlink address dump error: %w", err)
+	}
+	msgs, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return nil, fmt.Errorf("netlink parse error: %w", err)
+	}
+	names := make(map[int]string)
+	var sources []SourceAddress
+	for i := range msgs {
+		if msgs[i].Header.Type != syscall.RTM_NEWADDR {
+			continue
+		}
+		prefix, idx, flags, err := parseAddressMessage(&msgs[i])
+		if err != nil {
+			return nil, err
+		}
+		if (index != 0 && idx != index) || flags&(unix.IFA_F_TENTATIVE|unix.IFA_F_DADFAILED) != 0 {
+			continue
+		}
+		sources = append(sources, SourceAddress{
+			Prefix:     prefix,
+			Interface:  interfaceName(names, idx),
+			Deprecated: flags&unix.IFA_F_DEPRECATED != 0,
+			Temporary:  flags&unix.IFA_F_TEMPORARY != 0,
+		})
+	}
+	return sources, nil
+}
+
+// parseAddressMessage decodes a RTM_NEWADDR or RTM_DELADDR message:
+// the address with the prefix length of its subnet, the interface index and the IFA_F_* flags.
+func parseAddressMessage(m *syscall.NetlinkMessage) (netip.Prefix, int, uint32, error) {
+	// struct ifaddrmsg: family, prefixlen, flags, scope, index (u32)
+	if len(m.Data) < syscall.SizeofIfAddrmsg {
+		return netip.Prefix{}, 0, 0, fmt.Errorf("netlink address message too short: %d bytes", len(m.Data))
+	}
+	bits, flags := int(m.Data[1]), uint32(m.Data[2])
+	index := int(binary.NativeEndian.Uint32(m.Data[4:8]))
+	attrs, err := syscall.ParseNetlinkRouteAttr(m)
+	if err != nil {
+		return netip.Prefix{}, 0, 0, fmt.Errorf("netlink address attributes error: %w", err)
+	}
+	// IFA_LOCAL is the local address of point to point links (IFA_ADDRESS is the peer)
+	var address, local netip.Addr
+	for _, a := range attrs {
+		switch a.Attr.Type {
+		case syscall.IFA_ADDRESS:
+			address, _ = netip.AddrFromSlice(a.Value)
+		case syscall.IFA_LOCAL:
+			local, _ = netip.AddrFromSlice(a.Value)
+		case unix.IFA_FLAGS:
+			// the 32 bits flags, ifaddrmsg only has the first 8 ones
+			flags = nativeUint32(a.Value)
+		}
+	}
+	if local.IsValid() {
+		address = local
+	}
+	// the address with its prefix length, not the network
+	prefix := netip.PrefixFrom(address, bits)
+	if !prefix.IsValid() {
+		return prefix, 0, 0, fmt.Errorf("invalid address: %s/%d", address, bits)
+	}
+	return prefix, index, flags, nil
+}
@@ -0,0 +1,45 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !linux
+
+package network
+
+import (
+	"net"
+	"net/netip"
+)
+
+// GetSourceAddresses returns the candidate source addresses of an interface (all interfaces if iface is empty).
+// The deprecated and temporary flags are not available on this platform.
+func GetSourceAddresses(iface string) ([]SourceAddress, error) {
+	names := []string{iface}
+	if iface == "" {
+		names = nil
+	}
+	ifaces, err := GetNetInterfaces(names)
+	if err != nil {
+		return nil, err
+	}
+	var sources []SourceAddress
+	for _, itf := range ifaces {
+		addrs, err := itf.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			ipn, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			a, ok := netip.AddrFromSlice(ipn.IP)
+			if !ok {
+				continue
+			}
+			bits, _ := ipn.Mask.Size()
+			sources = append(sources, SourceAddress{Prefix: netip.PrefixFrom(a.Unmap(), bits), Interface: itf.Name})
+		}
+	}
+	return sources, nil
+}
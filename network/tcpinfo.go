@@ -0,0 +1,196 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrNotTCP is returned when a connection isn't (doesn't wrap) a TCP connection
+var ErrNotTCP = errors.New("not a TCP connection")
+
+// TCPInfo is a sample of the TCP_INFO of a connection (Linux).
+// The counters are cumulative since the start of the connection.
+type TCPInfo struct {
+	Time          time.Time
+	State         string // TCP state: "established", "close-wait" ...
+	CAState       string // congestion avoidance state: "open", "disorder", "cwr", "recovery" or "loss"
+	RTT           time.Duration
+	RTTVar        time.Duration
+	MinRTT        time.Duration
+	RTO           time.Duration
+	SndMSS        int
+	RcvMSS        int
+	PMTU          int
+	SndCwnd       int // congestion window in segments
+	SndSsthresh   int // slow start threshold in segments
+	SndWnd        int // window advertised by the peer in bytes
+	RcvWnd        int // window advertised to the peer in bytes
+	Unacked       int // segments in flight
+	Lost          int // segments presumed lost
+	Retrans       int // segments retransmitted and in flight
+	TotalRetrans  int // segments retransmitted
+	Reordering    int
+	NotSentBytes  int    // bytes in the send buffer not yet sent
+	PacingRate    uint64 // bytes per second
+	DeliveryRate  uint64 // bytes per second, of the last ack
+	BytesSent     uint64 // including the retransmissions
+	BytesRetrans  uint64
+	BytesAcked    uint64
+	BytesReceived uint64
+	SegsOut       int
+	SegsIn        int
+	BusyTime      time.Duration // time with data in flight
+	RwndLimited   time.Duration // time limited by the receive window of the peer
+	SndbufLimited time.Duration // time limited by the send buffer
+}
+
+// Limited returns what limited the sender the most since the start of the connection:
+// "rwnd" (the receiver), "sndbuf" (the send buffer, the application) or "cwnd" (the network)
+func (i TCPInfo) Limited() string {
+	switch {
+	case i.BusyTime == 0:
+		return ""
+	case i.RwndLimited >= i.SndbufLimited && i.RwndLimited > i.BusyTime/10:
+		return "rwnd"
+	case i.SndbufLimited > i.BusyTime/10:
+		return "sndbuf"
+	default:
+		return "cwnd"
+	}
+}
+
+func (i TCPInfo) String() string {
+	return fmt.Sprintf("%s rtt %s/%s minrtt %s cwnd %d ssthresh %d mss %d retrans %d/%d pacing %d bps delivery %d bps "+
+		"sent %d acked %d received %d busy %s rwnd-limited %s sndbuf-limited %s",
+		i.State, i.RTT, i.RTTVar, i.MinRTT, i.SndCwnd, i.SndSsthresh, i.SndMSS, i.Retrans, i.TotalRetrans,
+		i.PacingRate*8, i.DeliveryRate*8, i.BytesSent, i.BytesAcked, i.BytesReceived, i.BusyTime, i.RwndLimited, i.SndbufLimited)
+}
+
+// TCPConnOf returns the TCP connection of conn: conn itself or the connection it wraps.
+// Wrappers are unwrapped with their NetConn() method (tls.Conn, see also NewBufferedConn) or Unwrap() method.
+func TCPConnOf(conn net.Conn) (*net.TCPConn, error) {
+	for conn != nil {
+		switch c := conn.(type) {
+		case *net.TCPConn:
+			return c, nil
+		case interface{ NetConn() net.Conn }:
+			conn = c.NetConn()
+		case interface{ Unwrap() net.Conn }:
+			conn = c.Unwrap()
+		default:
+			return nil, ErrNotTCP
+		}
+	}
+	return nil, ErrNotTCP
+}
+
+// GetTCPInfo returns the TCP_INFO of conn, a TCP connection or a wrapper (see TCPConnOf)
+func GetTCPInfo(conn net.Conn) (TCPInfo, error) {
+	tc, err := TCPConnOf(conn)
+	if err != nil {
+		return TCPInfo{}, err
+	}
+	rc, err := tc.SyscallConn()
+	if err != nil {
+		return TCPInfo{}, err
+	}
+	return getTCPInfo(rc)
+}
+
+// TCPInfoSampler samples the TCP_INFO of a connection periodically
+type TCPInfoSampler struct {
+	rc      syscall.RawConn
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+	mu      sync.Mutex
+	samples []TCPInfo
+	err     error
+}
+
+// NewTCPInfoSampler starts sampling the TCP_INFO of conn (see GetTCPInfo) every interval,
+// the first sample is taken at once. Stop must be called before closing conn to get the last sample.
+func NewTCPInfoSampler(conn net.Conn, interval time.Duration) (*TCPInfoSampler, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid interval: %s", interval)
+	}
+	tc, err := TCPConnOf(conn)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := tc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	s := &TCPInfoSampler{rc: rc, done: make(chan struct{}), stopped: make(chan struct{})}
+	if err = s.sample(); err != nil {
+		return nil, err
+	}
+	go s.run(interval)
+	return s, nil
+}
+
+func (s *TCPInfoSampler) run(interval time.Duration) {
+	defer close(s.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if s.sample() != nil {
+				return
+			}
+		}
+	}
+}
+
+// sample records a sample, the first error stops the sampling
+func (s *TCPInfoSampler) sample() error {
+	info, err := getTCPInfo(s.rc)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		if s.err == nil {
+			s.err = err
+		}
+		return err
+	}
+	s.samples = append(s.samples, info)
+	return nil
+}
+
+// Samples returns a copy of the samples taken so far
+func (s *TCPInfoSampler) Samples() []TCPInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]TCPInfo(nil), s.samples...)
+}
+
+// Err returns the error which stopped the sampling (the connection is closed for instance), if any
+func (s *TCPInfoSampler) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Stop takes a last sample, stops the sampling and returns the samples
+func (s *TCPInfoSampler) Stop() []TCPInfo {
+	s.once.Do(func() {
+		close(s.done)
+		<-s.stopped
+		if s.Err() == nil {
+			_ = s.sample()
+		}
+	})
+	return s.Samples()
+}
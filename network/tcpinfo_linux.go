@@ -0,0 +1,77 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// TCP states of include/net/tcp_states.h
+var tcpStates = map[int]string{1: "established", 2: "syn-sent", 3: "syn-recv", 4: "fin-wait-1", 5: "fin-wait-2",
+	6: "time-wait", 7: "close", 8: "close-wait", 9: "last-ack", 10: "listen", 11: "closing", 12: "new-syn-recv"}
+
+// congestion avoidance states of include/uapi/linux/tcp.h
+var tcpCAStates = map[int]string{0: "open", 1: "disorder", 2: "cwr", 3: "recovery", 4: "loss"}
+
+func getTCPInfo(rc syscall.RawConn) (TCPInfo, error) {
+	var info *unix.TCPInfo
+	var err error
+	now := time.Now()
+	cerr := rc.Control(func(fd uintptr) {
+		info, err = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	})
+	if cerr != nil {
+		return TCPInfo{}, cerr
+	}
+	if err != nil {
+		return TCPInfo{}, err
+	}
+	return newTCPInfo(now, info), nil
+}
+
+// newTCPInfo converts a TCP_INFO, the fields unknown to older kernels are 0
+func newTCPInfo(now time.Time, info *unix.TCPInfo) TCPInfo {
+	us := func(v uint32) time.Duration { return time.Duration(v) * time.Microsecond }
+	r := TCPInfo{
+		Time:          now,
+		State:         nameOr(tcpStates, int(info.State)),
+		CAState:       nameOr(tcpCAStates, int(info.Ca_state)),
+		RTT:           us(info.Rtt),
+		RTTVar:        us(info.Rttvar),
+		MinRTT:        us(info.Min_rtt),
+		RTO:           us(info.Rto),
+		SndMSS:        int(info.Snd_mss),
+		RcvMSS:        int(info.Rcv_mss),
+		PMTU:          int(info.Pmtu),
+		SndCwnd:       int(info.Snd_cwnd),
+		SndSsthresh:   int(info.Snd_ssthresh),
+		SndWnd:        int(info.Snd_wnd),
+		RcvWnd:        int(info.Rcv_wnd),
+		Unacked:       int(info.Unacked),
+		Lost:          int(info.Lost),
+		Retrans:       int(info.Retrans),
+		TotalRetrans:  int(info.Total_retrans),
+		Reordering:    int(info.Reordering),
+		NotSentBytes:  int(info.Notsent_bytes),
+		PacingRate:    info.Pacing_rate,
+		DeliveryRate:  info.Delivery_rate,
+		BytesSent:     info.Bytes_sent,
+		BytesRetrans:  info.Bytes_retrans,
+		BytesAcked:    info.Bytes_acked,
+		BytesReceived: info.Bytes_received,
+		SegsOut:       int(info.Segs_out),
+		SegsIn:        int(info.Segs_in),
+		BusyTime:      time.Duration(info.Busy_time) * time.Microsecond,
+		RwndLimited:   time.Duration(info.Rwnd_limited) * time.Microsecond,
+		SndbufLimited: time.Duration(info.Sndbuf_limited) * time.Microsecond,
+	}
+	if info.Pacing_rate == ^uint64(0) {
+		r.PacingRate = 0 // unlimited
+	}
+	return r
+}
@@ -0,0 +1,76 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTCPInfoSampler(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			io.Copy(io.Discard, c)
+			c.Close()
+		}
+	}()
+	conn, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s, err := NewTCPInfoSampler(NewBufferedConn(conn, 0), 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const size = 16 << 20
+	b := make([]byte, 64<<10)
+	for sent := 0; sent < size; sent += len(b) {
+		if _, err = conn.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(30 * time.Millisecond)
+	samples := s.Stop()
+	if err = s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) < 3 {
+		t.Fatalf("%d samples", len(samples))
+	}
+	first, last := samples[0], samples[len(samples)-1]
+	if first.State != "established" || last.BytesAcked < size || last.BytesSent < size || last.SndCwnd == 0 || last.RTT <= 0 || last.SndMSS == 0 {
+		t.Errorf("last sample: %s", last)
+	}
+	for i := 1; i < len(samples); i++ {
+		if samples[i].BytesAcked < samples[i-1].BytesAcked || !samples[i].Time.After(samples[i-1].Time) {
+			t.Errorf("sample %d isn't after sample %d", i, i-1)
+		}
+	}
+	if len(s.Stop()) != len(samples) {
+		t.Error("Stop() should be idempotent")
+	}
+
+	// the sampling stops when the connection is closed
+	s, err = NewTCPInfoSampler(conn, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	time.Sleep(20 * time.Millisecond)
+	if s.Err() == nil {
+		t.Error("Err() should report the closed connection")
+	}
+	s.Stop()
+}
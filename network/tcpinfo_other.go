@@ -0,0 +1,16 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !linux
+
+package network
+
+import (
+	"errors"
+	"syscall"
+)
+
+func getTCPInfo(rc syscall.RawConn) (TCPInfo, error) {
+	return TCPInfo{}, errors.ErrUnsupported
+}
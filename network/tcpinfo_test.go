@@ -0,0 +1,75 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTCPConnOf(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	conn, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+
+	tests := []struct {
+		name    string
+		conn    net.Conn
+		wantErr bool
+	}{
+		{"tcp", conn, false},
+		{"tls", tls.Client(conn, &tls.Config{}), false},
+		{"buffered tls", NewBufferedConn(tls.Client(conn, &tls.Config{}), 0), false},
+		{"pipe", p1, true},
+		{"buffered pipe", NewBufferedConn(p1, 1024), true},
+		{"nil", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc, err := TCPConnOf(tt.conn)
+			if tt.wantErr {
+				if !errors.Is(err, ErrNotTCP) {
+					t.Errorf("TCPConnOf() error = %v, want ErrNotTCP", err)
+				}
+				return
+			}
+			if err != nil || tc != conn {
+				t.Errorf("TCPConnOf() = %v, %v", tc, err)
+			}
+		})
+	}
+}
+
+func TestTCPInfoLimited(t *testing.T) {
+	tests := []struct {
+		busy, rwnd, sndbuf time.Duration
+		want               string
+	}{
+		{0, 0, 0, ""},
+		{time.Second, 0, 0, "cwnd"},
+		{time.Second, 500 * time.Millisecond, 0, "rwnd"},
+		{time.Second, 200 * time.Millisecond, 300 * time.Millisecond, "sndbuf"},
+		{time.Second, 50 * time.Millisecond, 50 * time.Millisecond, "cwnd"},
+	}
+	for _, tt := range tests {
+		i := TCPInfo{BusyTime: tt.busy, RwndLimited: tt.rwnd, SndbufLimited: tt.sndbuf}
+		if got := i.Limited(); got != tt.want {
+			t.Errorf("Limited() of %+v = %q, want %q", tt, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// TCPOptions are the socket options of TCP connections, the zero value keeps the system defaults.
+// Except Nagle and Multipath, they are set before the connection (or on the listening socket), see TCPOptionsControl.
+type TCPOptions struct {
+	Congestion    string    // TCP_CONGESTION algorithm: "cubic", "bbr" ... (Linux only)
+	SendBuffer    int       // SO_SNDBUF in bytes, the kernel doubles it
+	ReceiveBuffer int       // SO_RCVBUF in bytes, the kernel doubles it
+	ForceBuffers  bool      // SO_SNDBUFFORCE and SO_RCVBUFFORCE to exceed net.core.wmem_max/rmem_max (CAP_NET_ADMIN), else the limits apply
+	NotSentLowat  int       // TCP_NOTSENT_LOWAT in bytes
+	Nagle         bool      // enable Nagle's algorithm (clear TCP_NODELAY), Go sets TCP_NODELAY by default
+	Multipath     MPTCPMode // Multipath TCP, see GetMPTCPInfo for the subflows
+}
+
+// TCPSettings are the effective socket options of a TCP connection
+type TCPSettings struct {
+	Congestion    string
+	SendBuffer    int
+	ReceiveBuffer int
+	NotSentLowat  int // 0 if not set
+	NoDelay       bool
+}
+
+func (s TCPSettings) String() string {
+	return fmt.Sprintf("congestion %s sndbuf %d rcvbuf %d notsent_lowat %d nodelay %v",
+		s.Congestion, s.SendBuffer, s.ReceiveBuffer, s.NotSentLowat, s.NoDelay)
+}
+
+// TCPOptionsControl returns a function setting the options o on a socket (see SetTCPOptions),
+// to be used as the Control function of a net.Dialer or a net.ListenConfig.
+// The accepted connections inherit the options of the listening socket except NotSentLowat.
+// Nagle is set after the connection and Multipath on the dialer: use TCPDialer and TCPListenConfig to set all the options.
+func TCPOptionsControl(o TCPOptions) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		return SetTCPOptions(c, o)
+	}
+}
+
+// GetTCPSettings returns the effective socket options of conn, a TCP connection or a wrapper (see TCPConnOf)
+func GetTCPSettings(conn net.Conn) (TCPSettings, error) {
+	tc, err := TCPConnOf(conn)
+	if err != nil {
+		return TCPSettings{}, err
+	}
+	rc, err := tc.SyscallConn()
+	if err != nil {
+		return TCPSettings{}, err
+	}
+	return getTCPSettings(rc)
+}
+
+// TCPDialer dials TCP connections with socket options
+type TCPDialer struct {
+	Dialer  net.Dialer // base dialer, its Control function is called before the options are set
+	Options TCPOptions
+}
+
+// Dial connects to the address on the named network (tcp, tcp4 or tcp6), see net.Dial
+func (d *TCPDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects to the address on the named network (tcp, tcp4 or tcp6) using the provided context
+func (d *TCPDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := d.Dialer
+	dialer.Control = tcpOptionsControl(d.Dialer.Control, d.Options)
+	d.Options.Multipath.apply(dialer.SetMultipathTCP)
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	if tc, ok := conn.(*net.TCPConn); ok && d.Options.Nagle {
+		if err = tc.SetNoDelay(false); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// TCPListenConfig listens on TCP sockets with socket options
+type TCPListenConfig struct {
+	ListenConfig net.ListenConfig // base configuration, its Control function is called before the options are set
+	Options      TCPOptions
+}
+
+// Listen announces on the local network address (tcp, tcp4 or tcp6), see net.ListenConfig.Listen
+func (l *TCPListenConfig) Listen(ctx context.Context, network, address string) (net.Listener, error) {
+	lc := l.ListenConfig
+	lc.Control = tcpOptionsControl(l.ListenConfig.Control, l.Options)
+	l.Options.Multipath.apply(lc.SetMultipathTCP)
+	ln, err := lc.Listen(ctx, network, address)
+	if err != nil || (!l.Options.Nagle && l.Options.NotSentLowat == 0) {
+		return ln, err
+	}
+	return &tcpOptionsListener{Listener: ln, options: l.Options}, nil
+}
+
+// tcpOptionsListener sets the options not inherited from the listening socket on the accepted connections:
+// Nagle and NotSentLowat
+type tcpOptionsListener struct {
+	net.Listener
+	options TCPOptions
+}
+
+func (l *tcpOptionsListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return conn, nil
+	}
+	if l.options.Nagle {
+		err = tc.SetNoDelay(false)
+	}
+	if err == nil && l.options.NotSentLowat > 0 {
+		var rc syscall.RawConn
+		if rc, err = tc.SyscallConn(); err == nil {
+			err = SetTCPOptions(rc, TCPOptions{NotSentLowat: l.options.NotSentLowat})
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// tcpOptionsControl returns a socket control function setting the options o after control (if not nil)
+func tcpOptionsControl(control func(network, address string, c syscall.RawConn) error, o TCPOptions) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		if control != nil {
+			if err := control(network, address, c); err != nil {
+				return err
+			}
+		}
+		return SetTCPOptions(c, o)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// SetTCPOptions sets the socket options o (except Nagle and Multipath) on a TCP socket
+func SetTCPOptions(rc syscall.RawConn, o TCPOptions) error {
+	var err error
+	cerr := rc.Control(func(fd uintptr) {
+		s := int(fd)
+		if o.Congestion != "" {
+			if err = unix.SetsockoptString(s, unix.IPPROTO_TCP, unix.TCP_CONGESTION, o.Congestion); err != nil {
+				switch {
+				case errors.Is(err, unix.ENOENT):
+					err = fmt.Errorf("congestion control %s is not available (net.ipv4.tcp_available_congestion_control): %w", o.Congestion, err)
+				case errors.Is(err, unix.EPERM):
+					err = fmt.Errorf("congestion control %s is not allowed (net.ipv4.tcp_allowed_congestion_control): %w", o.Congestion, err)
+				default:
+					err = fmt.Errorf("error setting congestion control %s: %w", o.Congestion, err)
+				}
+				return
+			}
+		}
+		if o.SendBuffer > 0 {
+			if err = setBuffer(s, unix.SO_SNDBUF, unix.SO_SNDBUFFORCE, o.SendBuffer, o.ForceBuffers); err != nil {
+				err = fmt.Errorf("error setting send buffer: %w", err)
+				return
+			}
+		}
+		if o.ReceiveBuffer > 0 {
+			if err = setBuffer(s, unix.SO_RCVBUF, unix.SO_RCVBUFFORCE, o.ReceiveBuffer, o.ForceBuffers); err != nil {
+				err = fmt.Errorf("error setting receive buffer: %w", err)
+				return
+			}
+		}
+		if o.NotSentLowat > 0 {
+			if err = unix.SetsockoptInt(s, unix.IPPROTO_TCP, unix.TCP_NOTSENT_LOWAT, o.NotSentLowat); err != nil {
+				err = fmt.Errorf("error setting TCP_NOTSENT_LOWAT: %w", err)
+				return
+			}
+		}
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// setBuffer sets a socket buffer size, with the force option if force and permitted
+func setBuffer(s, opt, forceOpt, size int, force bool) error {
+	if force {
+		err := unix.SetsockoptInt(s, unix.SOL_SOCKET, forceOpt, size)
+		if err == nil || !errors.Is(err, unix.EPERM) {
+			return err
+		}
+	}
+	return unix.SetsockoptInt(s, unix.SOL_SOCKET, opt, size)
+}
+
+func getTCPSettings(rc syscall.RawConn) (TCPSettings, error) {
+	var r TCPSettings
+	var err error
+	cerr := rc.Control(func(fd uintptr) {
+		s := int(fd)
+		if r.Congestion, err = unix.GetsockoptString(s, unix.IPPROTO_TCP, unix.TCP_CONGESTION); err != nil {
+			return
+		}
+		if r.SendBuffer, err = unix.GetsockoptInt(s, unix.SOL_SOCKET, unix.SO_SNDBUF); err != nil {
+			return
+		}
+		if r.ReceiveBuffer, err = unix.GetsockoptInt(s, unix.SOL_SOCKET, unix.SO_RCVBUF); err != nil {
+			return
+		}
+		if r.NotSentLowat, err = unix.GetsockoptInt(s, unix.IPPROTO_TCP, unix.TCP_NOTSENT_LOWAT); err != nil {
+			return
+		}
+		if r.NotSentLowat == -1 {
+			r.NotSentLowat = 0 // unset, net.ipv4.tcp_notsent_lowat is unlimited (UINT_MAX) by default
+		}
+		var nodelay int
+		nodelay, err = unix.GetsockoptInt(s, unix.IPPROTO_TCP, unix.TCP_NODELAY)
+		r.NoDelay = nodelay != 0
+	})
+	if cerr != nil {
+		return r, cerr
+	}
+	return r, err
+}
+
+// TCPCongestionControls returns the default congestion control algorithm, the available ones
+// and the ones allowed without privileges (CAP_NET_ADMIN)
+func TCPCongestionControls() (current string, available, allowed []string, err error) {
+	read := func(name string) ([]string, error) {
+		b, err := os.ReadFile("/proc/sys/net/ipv4/" + name)
+		return strings.Fields(string(b)), err
+	}
+	c, err := read("tcp_congestion_control")
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if len(c) > 0 {
+		current = c[0]
+	}
+	if available, err = read("tcp_available_congestion_control"); err != nil {
+		return "", nil, nil, err
+	}
+	if allowed, err = read("tcp_allowed_congestion_control"); err != nil {
+		return "", nil, nil, err
+	}
+	return current, available, allowed, nil
+}
@@ -0,0 +1,79 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestTCPOptions(t *testing.T) {
+	current, available, allowed, err := TCPCongestionControls()
+	if err != nil {
+		t.Skip(err)
+	}
+	if current == "" || len(available) == 0 || len(allowed) == 0 {
+		t.Errorf("TCPCongestionControls() = %s, %v, %v", current, available, allowed)
+	}
+
+	// reno is always built in
+	options := TCPOptions{Congestion: "reno", SendBuffer: 64 << 10, ReceiveBuffer: 128 << 10, NotSentLowat: 16 << 10, Nagle: true}
+	lc := TCPListenConfig{Options: options}
+	ln, err := lc.Listen(context.Background(), "tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- c
+	}()
+	d := TCPDialer{Options: options}
+	conn, err := d.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	server, ok := <-accepted
+	if !ok {
+		t.Fatal("accept failed")
+	}
+	defer server.Close()
+
+	for name, c := range map[string]net.Conn{"dialed": conn, "accepted": server} {
+		s, err := GetTCPSettings(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the kernel doubles the buffer sizes
+		if s.Congestion != "reno" || s.SendBuffer < options.SendBuffer || s.ReceiveBuffer < options.ReceiveBuffer ||
+			s.NotSentLowat != options.NotSentLowat || s.NoDelay {
+			t.Errorf("%s connection: %s", name, s)
+		}
+	}
+
+	// the defaults
+	var dd TCPDialer
+	conn2, err := dd.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	s, err := GetTCPSettings(conn2)
+	if err != nil || s.Congestion != current || s.NotSentLowat != 0 || !s.NoDelay {
+		t.Errorf("default settings: %s, %v", s, err)
+	}
+
+	dd.Options.Congestion = "nspeed-none"
+	if _, err = dd.Dial("tcp4", ln.Addr().String()); err == nil {
+		t.Error("Dial() with an unknown congestion control should fail")
+	}
+}
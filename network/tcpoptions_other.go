@@ -0,0 +1,30 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !linux
+
+package network
+
+import (
+	"errors"
+	"syscall"
+)
+
+// SetTCPOptions is only supported on Linux, the zero options are ignored
+func SetTCPOptions(rc syscall.RawConn, o TCPOptions) error {
+	o.Nagle, o.Multipath = false, MPTCPDefault // not socket options
+	if o == (TCPOptions{}) {
+		return nil
+	}
+	return errors.ErrUnsupported
+}
+
+func getTCPSettings(rc syscall.RawConn) (TCPSettings, error) {
+	return TCPSettings{}, errors.ErrUnsupported
+}
+
+// TCPCongestionControls is only supported on Linux
+func TCPCongestionControls() (current string, available, allowed []string, err error) {
+	return "", nil, nil, errors.ErrUnsupported
+}
@@ -0,0 +1,121 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// TrafficClass is the IPv4 TOS byte or the IPv6 Traffic Class: DSCP (6 bits) and ECN (2 bits)
+type TrafficClass uint8
+
+// ECN codepoints (RFC 3168)
+const (
+	ECNNotECT = 0 // not ECN-capable transport
+	ECNECT1   = 1 // ECN capable transport ECT(1)
+	ECNECT0   = 2 // ECN capable transport ECT(0)
+	ECNCE     = 3 // congestion experienced
+)
+
+// NewTrafficClass returns the traffic class made of dscp (0-63) and ecn (0-3)
+func NewTrafficClass(dscp, ecn int) TrafficClass {
+	return TrafficClass((dscp&0x3f)<<2 | ecn&0x3)
+}
+
+// DSCP returns the Differentiated Services Code Point
+func (t TrafficClass) DSCP() int {
+	return int(t >> 2)
+}
+
+// ECN returns the Explicit Congestion Notification bits
+func (t TrafficClass) ECN() int {
+	return int(t & 0x3)
+}
+
+var ecnNames = []string{"Not-ECT", "ECT(1)", "ECT(0)", "CE"}
+
+// DSCP names (RFC 2474, RFC 2597, RFC 3246, RFC 5865, RFC 8622)
+var dscpNames = map[int]string{
+	0: "CS0", 8: "CS1", 16: "CS2", 24: "CS3", 32: "CS4", 40: "CS5", 48: "CS6", 56: "CS7",
+	10: "AF11", 12: "AF12", 14: "AF13",
+	18: "AF21", 20: "AF22", 22: "AF23",
+	26: "AF31", 28: "AF32", 30: "AF33",
+	34: "AF41", 36: "AF42", 38: "AF43",
+	46: "EF", 44: "VA", 1: "LE",
+}
+
+// DSCPName returns the name of a DSCP ("EF", "AF41", ...) or its value if it has no name
+func DSCPName(dscp int) string {
+	if n, ok := dscpNames[dscp]; ok {
+		return n
+	}
+	return strconv.Itoa(dscp)
+}
+
+func (t TrafficClass) String() string {
+	if t.ECN() == ECNNotECT {
+		return DSCPName(t.DSCP())
+	}
+	return DSCPName(t.DSCP()) + " " + ecnNames[t.ECN()]
+}
+
+// ParseDSCP parses a DSCP name ("EF", "af41", "cs1", ...) or value (decimal or 0x hexadecimal)
+func ParseDSCP(s string) (int, error) {
+	for v, n := range dscpNames {
+		if strings.EqualFold(n, s) {
+			return v, nil
+		}
+	}
+	v, err := strconv.ParseUint(s, 0, 8)
+	if err != nil || v > 63 {
+		return 0, fmt.Errorf("invalid DSCP: %s", s)
+	}
+	return int(v), nil
+}
+
+// ParseECN parses an ECN name ("not-ect", "ect1", "ect(0)", "ce") or value (0-3)
+func ParseECN(s string) (int, error) {
+	n := strings.ToLower(strings.NewReplacer("(", "", ")", "").Replace(s))
+	for v, name := range []string{"not-ect", "ect1", "ect0", "ce"} {
+		if n == name {
+			return v, nil
+		}
+	}
+	v, err := strconv.ParseUint(s, 0, 8)
+	if err != nil || v > 3 {
+		return 0, fmt.Errorf("invalid ECN: %s", s)
+	}
+	return int(v), nil
+}
+
+// TrafficClassControl returns a function marking the packets sent by a socket with tc
+// (see SetTrafficClass), to be used as the Control function of a net.Dialer or a net.ListenConfig.
+// For datagram sockets ("udp" networks), the reception of the traffic class of the received
+// packets is also enabled (see SetReceiveTrafficClass) if the platform supports it.
+//
+// The packet conn of a QUIC endpoint can be marked by using it with a net.ListenConfig.
+func TrafficClassControl(tc TrafficClass) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		if err := SetTrafficClass(c, network, tc); err != nil {
+			return err
+		}
+		if strings.HasPrefix(network, "udp") {
+			if err := SetReceiveTrafficClass(c, network); err != nil && !errors.Is(err, errors.ErrUnsupported) {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// isIPv4Network returns true if network ("tcp4", "udp6", "ip4:icmp", ...) is an IPv4 only network
+func isIPv4Network(network string) bool {
+	n, _, _ := strings.Cut(network, ":")
+	return strings.HasSuffix(n, "4")
+}
@@ -0,0 +1,17 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !unix
+
+package network
+
+import (
+	"errors"
+	"syscall"
+)
+
+// SetTrafficClass is not supported on this platform
+func SetTrafficClass(rc syscall.RawConn, network string, tc TrafficClass) error {
+	return errors.New("traffic class is not supported on this platform")
+}
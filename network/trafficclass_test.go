@@ -0,0 +1,97 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"testing"
+)
+
+func TestTrafficClass(t *testing.T) {
+	tests := []struct {
+		name string
+		dscp string
+		ecn  string
+		want TrafficClass
+		str  string
+	}{
+		{"ef", "EF", "", 0xb8, "EF"},
+		{"af41 ect0", "af41", "ect(0)", 0x8a, "AF41 ECT(0)"},
+		{"cs1 ce", "CS1", "ce", 0x23, "CS1 CE"},
+		{"value", "5", "1", 0x15, "5 ECT(1)"},
+		{"hex", "0x2e", "0", 0xb8, "EF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dscp, err := ParseDSCP(tt.dscp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ecn := 0
+			if tt.ecn != "" {
+				if ecn, err = ParseECN(tt.ecn); err != nil {
+					t.Fatal(err)
+				}
+			}
+			tc := NewTrafficClass(dscp, ecn)
+			if tc != tt.want {
+				t.Errorf("NewTrafficClass() = %#x, want %#x", tc, tt.want)
+			}
+			if tc.DSCP() != dscp || tc.ECN() != ecn {
+				t.Errorf("DSCP(), ECN() = %d, %d want %d, %d", tc.DSCP(), tc.ECN(), dscp, ecn)
+			}
+			if tc.String() != tt.str {
+				t.Errorf("String() = %q, want %q", tc.String(), tt.str)
+			}
+		})
+	}
+	for _, s := range []string{"", "XX", "64"} {
+		if _, err := ParseDSCP(s); err == nil {
+			t.Errorf("ParseDSCP(%q) should fail", s)
+		}
+	}
+	if _, err := ParseECN("4"); err == nil {
+		t.Error("ParseECN(4) should fail")
+	}
+}
+
+func TestTrafficClassControl(t *testing.T) {
+	switch runtime.GOOS {
+	case "linux", "darwin", "freebsd":
+	default:
+		t.Skip("not supported on", runtime.GOOS)
+	}
+	tc := NewTrafficClass(46, ECNECT0)
+	for _, address := range []string{"127.0.0.1:0", "[::1]:0"} {
+		t.Run(address, func(t *testing.T) {
+			lc := net.ListenConfig{Control: TrafficClassControl(tc)}
+			pc, err := lc.ListenPacket(context.Background(), "udp", address)
+			if err != nil {
+				t.Skip(err)
+			}
+			defer pc.Close()
+			d := net.Dialer{Control: TrafficClassControl(tc)}
+			c, err := d.Dial("udp", pc.LocalAddr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			if _, err = c.Write([]byte("nspeed")); err != nil {
+				t.Fatal(err)
+			}
+			b, oob := make([]byte, 64), make([]byte, 128)
+			_, oobn, _, _, err := pc.(*net.UDPConn).ReadMsgUDP(b, oob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := ParseTrafficClass(oob[:oobn])
+			if !ok || got != tc {
+				t.Errorf("ParseTrafficClass() = %v, %v want %v, true", got, ok, tc)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build unix
+
+package network
+
+import (
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// SetTrafficClass sets the traffic class (IP_TOS or IPV6_TCLASS) of the packets sent by a socket
+// of network ("tcp4", "udp6", ...). The traffic class of an IPv6 socket also applies to its IPv4 traffic
+// (IPv4-mapped addresses) when the platform allows it.
+func SetTrafficClass(rc syscall.RawConn, network string, tc TrafficClass) error {
+	isIPv4 := isIPv4Network(network)
+	var err error
+	cerr := rc.Control(func(fd uintptr) {
+		if isIPv4 {
+			err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, int(tc))
+			return
+		}
+		err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_TCLASS, int(tc))
+		if err == nil {
+			// dual stack socket, not an error if not supported
+			_ = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, int(tc))
+		}
+	})
+	if cerr != nil {
+		return cerr
+	}
+	if err != nil {
+		return fmt.Errorf("error setting traffic class: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-netroute"
+)
+
+// ChangeKind is the kind of a network change
+type ChangeKind int
+
+const (
+	RouteAdded     ChangeKind = iota + 1 // a route was added or replaced
+	RouteDeleted                         // a route was deleted
+	LinkChanged                          // an interface was added or its state changed
+	LinkDeleted                          // an interface was deleted
+	AddressAdded                         // an address was added to an interface
+	AddressDeleted                       // an address was removed from an interface
+	ChangesLost                          // changes were lost (the watcher was too slow), the state must be read again
+)
+
+var changeKindNames = map[ChangeKind]string{
+	RouteAdded: "route added", RouteDeleted: "route deleted", LinkChanged: "link changed", LinkDeleted: "link deleted",
+	AddressAdded: "address added", AddressDeleted: "address deleted", ChangesLost: "changes lost",
+}
+
+func (k ChangeKind) String() string {
+	if n, ok := changeKindNames[k]; ok {
+		return n
+	}
+	return fmt.Sprintf("change(%d)", int(k))
+}
+
+// Change is a route, link or address change notified by a Watcher
+type Change struct {
+	Kind      ChangeKind
+	Time      time.Time    // time the change was received
+	Interface string       // name of the interface
+	Index     int          // index of the interface
+	Route     Route        // RouteAdded and RouteDeleted
+	Up        bool         // LinkChanged: the interface is administratively up
+	Running   bool         // LinkChanged: the interface is operational (carrier)
+	Address   netip.Prefix // AddressAdded and AddressDeleted
+}
+
+func (c Change) String() string {
+	switch c.Kind {
+	case RouteAdded, RouteDeleted:
+		return fmt.Sprintf("%s: %s", c.Kind, c.Route)
+	case LinkChanged:
+		return fmt.Sprintf("%s: %s up=%t running=%t", c.Kind, c.Interface, c.Up, c.Running)
+	case LinkDeleted:
+		return fmt.Sprintf("%s: %s", c.Kind, c.Interface)
+	case AddressAdded, AddressDeleted:
+		return fmt.Sprintf("%s: %s dev %s", c.Kind, c.Address, c.Interface)
+	default:
+		return c.Kind.String()
+	}
+}
+
+// Watcher notifies the route, link and address changes of the host, see NewWatcher
+type Watcher struct {
+	changes chan Change
+	done    chan struct{}
+	conn    io.Closer
+	once    sync.Once
+	mu      sync.Mutex
+	err     error
+}
+
+// Changes returns the channel of the changes. It's closed when the watcher is closed or fails, see Err.
+func (w *Watcher) Changes() <-chan Change {
+	return w.changes
+}
+
+// Err returns the error that stopped the watcher, nil if it was closed
+func (w *Watcher) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Close stops the watcher
+func (w *Watcher) Close() error {
+	var err error
+	w.once.Do(func() {
+		close(w.done)
+		err = w.conn.Close()
+	})
+	return err
+}
+
+// notify sends a change, false if the watcher is closed
+func (w *Watcher) notify(c Change) bool {
+	select {
+	case w.changes <- c:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+// Path is the route to a destination, as computed by GetRoute
+type Path struct {
+	Interface string
+	Index     int
+	Gateway   netip.Addr // invalid for a direct route
+	Source    netip.Addr
+	Err       error // the destination is unreachable
+}
+
+// Equal reports whether p and o are the same path
+func (p Path) Equal(o Path) bool {
+	return p.Index == o.Index && p.Gateway == o.Gateway && p.Source == o.Source && (p.Err == nil) == (o.Err == nil)
+}
+
+func (p Path) String() string {
+	if p.Err != nil {
+		return p.Err.Error()
+	}
+	if p.Gateway.IsValid() {
+		return fmt.Sprintf("via %s dev %s src %s", p.Gateway, p.Interface, p.Source)
+	}
+	return fmt.Sprintf("dev %s src %s", p.Interface, p.Source)
+}
+
+// CurrentPath returns the current path to destination.
+// Unlike GetRoute it reads the routing table again.
+func CurrentPath(destination netip.Addr) Path {
+	router, err := netroute.New()
+	if err != nil {
+		return Path{Err: fmt.Errorf("router error: %w", err)}
+	}
+	iface, g, s, err := router.Route(destination.AsSlice())
+	if err != nil {
+		return Path{Err: fmt.Errorf("router.Route error: %w", err)}
+	}
+	p := Path{Interface: iface.Name, Index: iface.Index}
+	p.Gateway, _ = netip.AddrFromSlice(g)
+	p.Source, _ = netip.AddrFromSlice(s)
+	p.Gateway, p.Source = p.Gateway.Unmap(), p.Source.Unmap()
+	return p
+}
+
+// PathChange is a change of the path to a destination, see WatchPath
+type PathChange struct {
+	Destination netip.Addr
+	Old, New    Path
+	Cause       Change // the change after which the new path was computed
+}
+
+func (c PathChange) String() string {
+	return fmt.Sprintf("path to %s changed from %s to %s (%s)", c.Destination, c.Old, c.New, c.Cause)
+}
+
+// WatchPath returns the current path to destination and a channel of its changes,
+// so a running job can be annotated or aborted when its path changes.
+// The channel is closed when ctx is done or if the watcher fails.
+func WatchPath(ctx context.Context, destination netip.Addr) (Path, <-chan PathChange, error) {
+	w, err := NewWatcher()
+	if err != nil {
+		return Path{}, nil, err
+	}
+	// the watcher is started first to not miss a change
+	path := CurrentPath(destination)
+	changes := make(chan PathChange, 1)
+	go func() {
+		defer close(changes)
+		defer w.Close()
+		current := path
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case c, ok := <-w.Changes():
+				if !ok {
+					return
+				}
+				p := CurrentPath(destination)
+				if p.Equal(current) {
+					continue
+				}
+				select {
+				case changes <- PathChange{Destination: destination, Old: current, New: p, Cause: c}:
+				case <-ctx.Done():
+					return
+				}
+				current = p
+			}
+		}
+	}()
+	return path, changes, nil
+}
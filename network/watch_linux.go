@@ -0,0 +1,148 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// NewWatcher returns a watcher of the route, link and address changes (netlink events).
+// It must be closed after use.
+func NewWatcher() (*Watcher, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("netlink socket error: %w", err)
+	}
+	groups := unix.RTMGRP_LINK | unix.RTMGRP_IPV4_IFADDR | unix.RTMGRP_IPV6_IFADDR | unix.RTMGRP_IPV4_ROUTE | unix.RTMGRP_IPV6_ROUTE
+	if err = unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: uint32(groups)}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("netlink bind error: %w", err)
+	}
+	// a larger buffer for the bursts of a route flip, best effort
+	_ = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF, 1<<20)
+	f := os.NewFile(uintptr(fd), "netlink")
+	w := &Watcher{changes: make(chan Change, 64), done: make(chan struct{}), conn: f}
+	go w.run(f)
+	return w, nil
+}
+
+func (w *Watcher) run(f *os.File) {
+	defer close(w.changes)
+	names := make(map[int]string)
+	b := make([]byte, 64*1024)
+	for {
+		n, err := f.Read(b)
+		if errors.Is(err, syscall.ENOBUFS) {
+			if !w.notify(Change{Kind: ChangesLost, Time: time.Now()}) {
+				return
+			}
+			continue
+		}
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				w.mu.Lock()
+				w.err = fmt.Errorf("netlink read error: %w", err)
+				w.mu.Unlock()
+			}
+			return
+		}
+		msgs, err := syscall.ParseNetlinkMessage(b[:n])
+		if err != nil {
+			continue
+		}
+		now := time.Now()
+		for i := range msgs {
+			c, ok := parseChange(&msgs[i], names)
+			if !ok {
+				continue
+			}
+			c.Time = now
+			if !w.notify(c) {
+				return
+			}
+		}
+	}
+}
+
+// parseChange decodes a netlink route, link or address message,
+// names caches the interface names (deleted interfaces can't be looked up).
+func parseChange(m *syscall.NetlinkMessage, names map[int]string) (Change, bool) {
+	var c Change
+	switch m.Header.Type {
+	case syscall.RTM_NEWROUTE, syscall.RTM_DELROUTE:
+		c.Kind = RouteAdded
+		if m.Header.Type == syscall.RTM_DELROUTE {
+			c.Kind = RouteDeleted
+		}
+		// the cloned routes are the route cache (PMTU exceptions ...), not the table
+		if len(m.Data) >= syscall.SizeofRtMsg && binary.NativeEndian.Uint32(m.Data[8:12])&syscall.RTM_F_CLONED != 0 {
+			return c, false
+		}
+		r, err := parseRouteMessage(m)
+		if err != nil {
+			return c, false
+		}
+		r.Interface = interfaceName(names, r.Index)
+		for k := range r.NextHops {
+			r.NextHops[k].Interface = interfaceName(names, r.NextHops[k].Index)
+		}
+		c.Route, c.Interface, c.Index = r, r.Interface, r.Index
+	case syscall.RTM_NEWLINK, syscall.RTM_DELLINK:
+		// struct ifinfomsg: family, pad, type (u16), index (i32), flags (u32), change (u32)
+		if len(m.Data) < syscall.SizeofIfInfomsg {
+			return c, false
+		}
+		c.Kind = LinkChanged
+		if m.Header.Type == syscall.RTM_DELLINK {
+			c.Kind = LinkDeleted
+		}
+		c.Index = int(binary.NativeEndian.Uint32(m.Data[4:8]))
+		flags := binary.NativeEndian.Uint32(m.Data[8:12])
+		c.Up, c.Running = flags&syscall.IFF_UP != 0, flags&syscall.IFF_RUNNING != 0
+		attrs, err := syscall.ParseNetlinkRouteAttr(m)
+		if err != nil {
+			return c, false
+		}
+		for _, a := range attrs {
+			if a.Attr.Type == syscall.IFLA_IFNAME {
+				names[c.Index] = string(trimNul(a.Value))
+			}
+		}
+		c.Interface = interfaceName(names, c.Index)
+		if c.Kind == LinkDeleted {
+			delete(names, c.Index)
+		}
+	case syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+		c.Kind = AddressAdded
+		if m.Header.Type == syscall.RTM_DELADDR {
+			c.Kind = AddressDeleted
+		}
+		address, index, _, err := parseAddressMessage(m)
+		if err != nil {
+			return c, false
+		}
+		c.Address, c.Index = address, index
+		c.Interface = interfaceName(names, c.Index)
+	default:
+		return c, false
+	}
+	return c, true
+}
+
+func trimNul(b []byte) []byte {
+	for i, v := range b {
+		if v == 0 {
+			return b[:i]
+		}
+	}
+	return b
+}
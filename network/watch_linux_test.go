@@ -0,0 +1,93 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package network
+
+import (
+	"context"
+	"encoding/binary"
+	"net/netip"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseChange(t *testing.T) {
+	names := make(map[int]string)
+
+	// link 7 "wlan0" up without carrier
+	data := make([]byte, syscall.SizeofIfInfomsg)
+	binary.NativeEndian.PutUint32(data[4:8], 7)
+	binary.NativeEndian.PutUint32(data[8:12], syscall.IFF_UP)
+	data = append(data, rtAttr(syscall.IFLA_IFNAME, []byte("wlan0\x00"))...)
+	c, ok := parseChange(&syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: syscall.RTM_NEWLINK}, Data: data}, names)
+	if !ok || c.Kind != LinkChanged || c.Interface != "wlan0" || c.Index != 7 || !c.Up || c.Running {
+		t.Errorf("parseChange(link) = %+v, %v", c, ok)
+	}
+
+	// address 192.168.1.10/24 added to interface 7, the name comes from the link
+	data = []byte{syscall.AF_INET, 24, 0, 0, 7, 0, 0, 0}
+	binary.NativeEndian.PutUint32(data[4:8], 7)
+	data = append(data, rtAttr(syscall.IFA_ADDRESS, []byte{192, 168, 1, 10})...)
+	c, ok = parseChange(&syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: syscall.RTM_NEWADDR}, Data: data}, names)
+	if !ok || c.Kind != AddressAdded || c.Address != netip.MustParsePrefix("192.168.1.10/24") || c.Interface != "wlan0" {
+		t.Errorf("parseChange(address) = %+v, %v", c, ok)
+	}
+	if s := c.String(); s != "address added: 192.168.1.10/24 dev wlan0" {
+		t.Errorf("String() = %q", s)
+	}
+
+	// default route deleted
+	data = []byte{syscall.AF_INET, 0, 0, 0, RouteTableMain, 16, 0, 1, 0, 0, 0, 0}
+	data = append(data, rtAttr(syscall.RTA_GATEWAY, []byte{192, 168, 1, 1})...)
+	data = append(data, rtAttr(syscall.RTA_OIF, u32(7))...)
+	c, ok = parseChange(&syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: syscall.RTM_DELROUTE}, Data: data}, names)
+	if !ok || c.Kind != RouteDeleted || c.String() != "route deleted: default via 192.168.1.1 dev wlan0 proto dhcp" {
+		t.Errorf("parseChange(route) = %v, %v", c, ok)
+	}
+
+	// cloned routes are ignored
+	binary.NativeEndian.PutUint32(data[8:12], syscall.RTM_F_CLONED)
+	if _, ok = parseChange(&syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: syscall.RTM_NEWROUTE}, Data: data}, names); ok {
+		t.Error("parseChange() of a cloned route should be ignored")
+	}
+}
+
+func TestWatchPath(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	path, changes, err := WatchPath(ctx, netip.MustParseAddr("127.0.0.1"))
+	if err != nil {
+		t.Skip(err)
+	}
+	if path.Err != nil || path.Interface == "" {
+		t.Errorf("WatchPath() path = %+v", path)
+	}
+	cancel()
+	select {
+	case _, ok := <-changes:
+		for ok {
+			_, ok = <-changes
+		}
+	case <-time.After(time.Second):
+		t.Error("the changes channel is not closed after cancel")
+	}
+}
+
+func TestWatcherClose(t *testing.T) {
+	w, err := NewWatcher()
+	if err != nil {
+		t.Skip(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-w.Changes():
+	case <-time.After(time.Second):
+		t.Fatal("the changes channel is not closed after Close")
+	}
+	if w.Err() != nil {
+		t.Errorf("Err() = %v after Close", w.Err())
+	}
+}
@@ -0,0 +1,16 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !linux
+
+package network
+
+import (
+	"errors"
+)
+
+// NewWatcher is not supported on this platform
+func NewWatcher() (*Watcher, error) {
+	return nil, errors.New("network change watcher is not supported on this platform")
+}
@@ -84,6 +84,28 @@ func ParsePacingSchedule(scheduleStr string) (*Schedule, error) {
 	return NewPacingSchedule(durations...)
 }
 
+// WorkAt returns true if elapsed (the time since the start of the schedule) is in a work phase.
+// A nil Schedule always works, as does a Schedule after its last phase.
+func (ps *Schedule) WorkAt(elapsed time.Duration) bool {
+	if ps == nil {
+		return true
+	}
+	var end time.Duration
+	for _, p := range ps.phases {
+		if p.duration == 0 {
+			if p.hold {
+				continue // 0 duration hold is instant
+			}
+			return true // 0 duration work is infinite
+		}
+		end += p.duration
+		if elapsed < end {
+			return !p.hold
+		}
+	}
+	return true
+}
+
 // Pacer manages the timing of phases
 type Pacer struct {
 	ctx      context.Context
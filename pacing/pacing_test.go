@@ -246,3 +246,28 @@ func TestPacingSchedule_String(t *testing.T) {
 		})
 	}
 }
+
+func TestScheduleWorkAt(t *testing.T) {
+	s, _ := NewPacingSchedule(2*time.Second, 5*time.Second, 1*time.Second)
+	tests := []struct {
+		elapsed time.Duration
+		want    bool
+	}{
+		{0, false},
+		{1999 * time.Millisecond, false},
+		{2 * time.Second, true},
+		{6 * time.Second, true},
+		{7 * time.Second, false},
+		{8 * time.Second, true}, // last work phase is infinite
+		{time.Hour, true},
+	}
+	for _, tt := range tests {
+		if got := s.WorkAt(tt.elapsed); got != tt.want {
+			t.Errorf("WorkAt(%v) = %v, want %v", tt.elapsed, got, tt.want)
+		}
+	}
+	var nilSchedule *Schedule
+	if !nilSchedule.WorkAt(0) {
+		t.Error("nil schedule should always work")
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ping
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"nspeed.app/nspeed/pacing"
+)
+
+// Workload is the load applied during a latency under load measure (a download for instance).
+// It should pace its work with schedule (see pacing.NewPacedReader) and return when
+// its work is done or ctx is canceled.
+type Workload func(ctx context.Context, schedule *pacing.Schedule) error
+
+// LoadOptions are the options of MeasureLatencyUnderLoad
+type LoadOptions struct {
+	Interval time.Duration    // delay between probes, 0 = DefaultLoadInterval
+	Timeout  time.Duration    // time to wait for a probe response, 0 = DefaultTimeout
+	Before   time.Duration    // duration of the idle measure before the workload, 0 = DefaultIdleDuration
+	After    time.Duration    // duration of the idle measure after the workload, 0 = DefaultIdleDuration
+	Schedule *pacing.Schedule // schedule of the workload, nil = the workload works all the time
+}
+
+const (
+	DefaultLoadInterval = 100 * time.Millisecond // delay between probes of a latency under load measure
+	DefaultIdleDuration = 2 * time.Second        // duration of the idle measures before and after the load
+)
+
+// LoadPhase is the phase of a latency under load measure
+type LoadPhase int
+
+const (
+	LoadPhaseBefore LoadPhase = 0 // idle, before the workload
+	LoadPhaseLoaded LoadPhase = 1 // during a work phase of the workload
+	LoadPhaseHold   LoadPhase = 2 // during a hold phase of the workload
+	LoadPhaseAfter  LoadPhase = 3 // idle, after the workload
+)
+
+var loadPhaseNames = map[LoadPhase]string{
+	LoadPhaseBefore: "before",
+	LoadPhaseLoaded: "loaded",
+	LoadPhaseHold:   "hold",
+	LoadPhaseAfter:  "after",
+}
+
+func (p LoadPhase) String() string {
+	n, ok := loadPhaseNames[p]
+	if ok {
+		return n
+	}
+	return "invalid"
+}
+
+// LoadSample is the result of a probe of a latency under load measure
+type LoadSample struct {
+	Time  time.Time     // time the probe was sent
+	Phase LoadPhase     // phase at the time the probe was sent
+	RTT   time.Duration // round trip time, 0 if lost
+	Lost  bool          // no response (or a probe error)
+}
+
+// Grade is a bufferbloat grade, from "A+" (no latency increase under load) to "F"
+type Grade string
+
+// grades by max latency increase (same thresholds as the Waveform bufferbloat test)
+var grades = []struct {
+	delta time.Duration
+	grade Grade
+}{
+	{5 * time.Millisecond, "A+"},
+	{30 * time.Millisecond, "A"},
+	{60 * time.Millisecond, "B"},
+	{200 * time.Millisecond, "C"},
+	{400 * time.Millisecond, "D"},
+}
+
+// GradeOf returns the grade of a latency increase under load
+func GradeOf(delta time.Duration) Grade {
+	for _, g := range grades {
+		if delta < g.delta {
+			return g.grade
+		}
+	}
+	return "F"
+}
+
+// LoadReport is the result of a latency under load measure
+type LoadReport struct {
+	Before Statistics // idle, before the workload
+	Loaded Statistics // during the work phases of the workload
+	Hold   Statistics // during the hold phases of the workload
+	After  Statistics // idle, after the workload
+	Idle   Statistics // Before and After combined
+
+	Delta time.Duration // latency increase under load: Loaded.Avg - Idle.Avg
+	Grade Grade         // grade of Delta, "" if there are not enough samples
+
+	Samples []LoadSample
+	Err     error // error returned by the workload
+}
+
+func (r *LoadReport) String() string {
+	if r.Grade == "" {
+		return fmt.Sprintf("idle %.3f ms (%d samples), loaded %.3f ms (%d samples): not enough samples",
+			ms(r.Idle.Avg), r.Idle.Received, ms(r.Loaded.Avg), r.Loaded.Received)
+	}
+	return fmt.Sprintf("idle %.3f ms, loaded %.3f ms, delta %+.3f ms, loss %.4g%%, grade %s",
+		ms(r.Idle.Avg), ms(r.Loaded.Avg), ms(r.Delta), r.Loaded.PacketLoss(), r.Grade)
+}
+
+// MeasureLatencyUnderLoad measures the latency with prober before, during and after workload (bufferbloat measure).
+// A probe is sent every options.Interval, probes run concurrently so a slow response doesn't delay the series.
+// The workload error, if any, is returned in the report. The error is only about the measure itself.
+func MeasureLatencyUnderLoad(ctx context.Context, prober Prober, workload Workload, options LoadOptions) (*LoadReport, error) {
+	if options.Interval <= 0 {
+		options.Interval = DefaultLoadInterval
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultTimeout
+	}
+	if options.Before <= 0 {
+		options.Before = DefaultIdleDuration
+	}
+	if options.After <= 0 {
+		options.After = DefaultIdleDuration
+	}
+
+	var phase atomic.Int32 // current LoadPhase, LoadPhaseLoaded = the workload is running
+	var workStart atomic.Int64
+	currentPhase := func() LoadPhase {
+		p := LoadPhase(phase.Load())
+		if p == LoadPhaseLoaded && !options.Schedule.WorkAt(time.Since(time.Unix(0, workStart.Load()))) {
+			return LoadPhaseHold
+		}
+		return p
+	}
+
+	var mu sync.Mutex
+	var samples []LoadSample
+	var wg sync.WaitGroup
+	probe := func() {
+		defer wg.Done()
+		s := LoadSample{Time: time.Now(), Phase: currentPhase()}
+		pctx, cancel := context.WithTimeout(ctx, options.Timeout)
+		r, err := prober.Probe(pctx)
+		cancel()
+		if err != nil || r.Response != PingResponseEchoReply {
+			s.Lost = true
+		} else {
+			s.RTT = r.RTT
+		}
+		mu.Lock()
+		samples = append(samples, s)
+		mu.Unlock()
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(options.Interval)
+		defer ticker.Stop()
+		for {
+			wg.Add(1)
+			go probe()
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	report := &LoadReport{}
+	sleep := func(d time.Duration) error {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-t.C:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	err := sleep(options.Before)
+	if err == nil {
+		workStart.Store(time.Now().UnixNano())
+		phase.Store(int32(LoadPhaseLoaded))
+		report.Err = workload(ctx, options.Schedule)
+		phase.Store(int32(LoadPhaseAfter))
+		err = sleep(options.After)
+	}
+	// let the probes in flight finish
+	close(stop)
+	<-done
+	wg.Wait()
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(samples, func(a, b LoadSample) int { return a.Time.Compare(b.Time) })
+	for _, s := range samples {
+		var stats []*Statistics
+		switch s.Phase {
+		case LoadPhaseBefore:
+			stats = []*Statistics{&report.Before, &report.Idle}
+		case LoadPhaseLoaded:
+			stats = []*Statistics{&report.Loaded}
+		case LoadPhaseHold:
+			stats = []*Statistics{&report.Hold}
+		case LoadPhaseAfter:
+			stats = []*Statistics{&report.After, &report.Idle}
+		}
+		for _, st := range stats {
+			st.Transmitted++
+			if !s.Lost {
+				st.add(s.RTT)
+			}
+		}
+	}
+	report.Samples = samples
+	if report.Idle.Received > 0 && report.Loaded.Received > 0 {
+		report.Delta = report.Loaded.Avg - report.Idle.Avg
+		report.Grade = GradeOf(report.Delta)
+	}
+	return report, nil
+}
@@ -0,0 +1,87 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ping
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"nspeed.app/nspeed/pacing"
+)
+
+// fakeProber responds in 1ms or in 50ms when loaded
+type fakeProber struct {
+	loaded atomic.Bool
+}
+
+func (p *fakeProber) Probe(ctx context.Context) (Result, error) {
+	rtt := time.Millisecond
+	if p.loaded.Load() {
+		rtt = 50 * time.Millisecond
+	}
+	return Result{RTT: rtt, Response: PingResponseEchoReply}, nil
+}
+
+func TestMeasureLatencyUnderLoad(t *testing.T) {
+	prober := &fakeProber{}
+	errWork := errors.New("work error")
+	workload := func(ctx context.Context, schedule *pacing.Schedule) error {
+		prober.loaded.Store(true)
+		defer prober.loaded.Store(false)
+		time.Sleep(200 * time.Millisecond)
+		return errWork
+	}
+	options := LoadOptions{Interval: 10 * time.Millisecond, Before: 100 * time.Millisecond, After: 100 * time.Millisecond}
+	report, err := MeasureLatencyUnderLoad(context.Background(), prober, workload, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Err != errWork {
+		t.Errorf("Err = %v, want %v", report.Err, errWork)
+	}
+	if report.Before.Received == 0 || report.Loaded.Received == 0 || report.After.Received == 0 {
+		t.Fatalf("missing samples: before %d, loaded %d, after %d", report.Before.Received, report.Loaded.Received, report.After.Received)
+	}
+	if report.Idle.Received != report.Before.Received+report.After.Received {
+		t.Errorf("Idle.Received = %d, want %d", report.Idle.Received, report.Before.Received+report.After.Received)
+	}
+	// a few probes may be sent right at a phase change
+	if report.Delta < 30*time.Millisecond || report.Grade != "B" {
+		t.Errorf("Delta = %v, Grade = %q, want ~49ms and B", report.Delta, report.Grade)
+	}
+}
+
+func TestMeasureLatencyUnderLoadCanceled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	workload := func(ctx context.Context, schedule *pacing.Schedule) error { return nil }
+	_, err := MeasureLatencyUnderLoad(ctx, &fakeProber{}, workload, LoadOptions{Before: time.Second})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestGradeOf(t *testing.T) {
+	tests := []struct {
+		delta time.Duration
+		want  Grade
+	}{
+		{-time.Millisecond, "A+"},
+		{4 * time.Millisecond, "A+"},
+		{29 * time.Millisecond, "A"},
+		{59 * time.Millisecond, "B"},
+		{199 * time.Millisecond, "C"},
+		{399 * time.Millisecond, "D"},
+		{time.Second, "F"},
+	}
+	for _, tt := range tests {
+		if got := GradeOf(tt.delta); got != tt.want {
+			t.Errorf("GradeOf(%v) = %v, want %v", tt.delta, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ping
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"os"
+	"sync/atomic"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"nspeed.app/nspeed/iana"
+)
+
+// A raw ICMP socket receives every ICMP message received by the host, including
+// replies to the echo requests of other processes or of other goroutines.
+// Each echo request we send carries an ID unique to the process/session and,
+// when the packet size allows it, a random token at the start of its payload.
+// A received message is ours if it is an echo reply or an ICMP error quoting
+// one of our echo requests with the same ID, the same token and a known Seq.
+
+const tokenSize = 8 // size of the random token in the echo payload
+
+var idCounter atomic.Uint32
+
+// nextID returns an echo ID which differs from the previous ones of this process
+func nextID() int {
+	return (os.Getpid() + int(idCounter.Add(1))) & 0xffff
+}
+
+// newToken returns a random token
+func newToken() []byte {
+	token := make([]byte, tokenSize)
+	_, _ = rand.Read(token)
+	return token
+}
+
+// payload returns an echo payload of size bytes starting with token (if size allows it)
+func payload(size int, token []byte) []byte {
+	data := make([]byte, size)
+	if size >= len(token) {
+		copy(data, token)
+	}
+	return data
+}
+
+// reply is a received ICMP message related to one of our probes
+type reply struct {
+	message   *icmp.Message
+	echo      *icmp.Echo // our echo request or its reply, nil if the quoted datagram isn't an echo request
+	dst       netip.Addr // destination of the quoted datagram (invalid for an echo reply)
+	protocol  int        // protocol of the quoted datagram
+	transport []byte     // transport header and data of the quoted datagram
+	quoted    []byte     // quoted original datagram (nil for an echo reply)
+	header    *QuotedHeader
+	mtu       int // next-hop MTU of a Packet Too Big or Fragmentation Needed error, 0 if unknown
+}
+
+var errNotEcho = errors.New("not related to an echo request")
+var errNotQuoted = errors.New("no valid quoted datagram")
+
+// parseReply parses an ICMP message (without IP header) and extracts the echo message it is about:
+// the message itself for an echo reply or the quoted echo request inside an ICMP error.
+// For an ICMP error about another protocol (UDP, TCP), only the transport part of the quoted datagram is set.
+func parseReply(protonumber int, b []byte) (*reply, error) {
+	rm, err := icmp.ParseMessage(protonumber, b)
+	if err != nil {
+		return nil, err
+	}
+	r := &reply{message: rm}
+	switch body := rm.Body.(type) {
+	case *icmp.Echo:
+		if rm.Type != ipv4.ICMPTypeEchoReply && rm.Type != ipv6.ICMPTypeEchoReply {
+			return nil, errNotEcho
+		}
+		r.echo = body
+		return r, nil
+	case *icmp.DstUnreach:
+		r.quoted = body.Data
+		if rm.Type == ipv4.ICMPTypeDestinationUnreachable && rm.Code == 4 && len(b) >= 8 {
+			// fragmentation needed: RFC 1191 next-hop MTU in the 'unused' field
+			r.mtu = int(binary.BigEndian.Uint16(b[6:8]))
+		}
+	case *icmp.TimeExceeded:
+		r.quoted = body.Data
+	case *icmp.PacketTooBig:
+		r.quoted = body.Data
+		r.mtu = body.MTU
+	case *icmp.ParamProb:
+		r.quoted = body.Data
+	default:
+		return nil, errNotEcho
+	}
+	r.header, r.transport, err = parseQuoted(r.quoted)
+	if err != nil {
+		return nil, err
+	}
+	r.dst = r.header.Dst
+	r.protocol = r.header.Protocol
+	r.echo = parseEchoRequest(r.protocol, r.transport)
+	return r, nil
+}
+
+// parseQuoted parses the original datagram quoted in an ICMP error
+// and returns its header and its transport part.
+func parseQuoted(b []byte) (*QuotedHeader, []byte, error) {
+	if len(b) < 1 {
+		return nil, nil, errNotQuoted
+	}
+	q := &QuotedHeader{Version: int(b[0] >> 4), Data: b}
+	switch q.Version {
+	case 4:
+		h, err := icmp.ParseIPv4Header(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		if h.Len > len(b) {
+			return nil, nil, errNotQuoted
+		}
+		q.Src, _ = netip.AddrFromSlice(h.Src.To4())
+		q.Dst, _ = netip.AddrFromSlice(h.Dst.To4())
+		q.Protocol = h.Protocol
+		q.TTL = h.TTL
+		q.TOS = h.TOS
+		q.Length = h.TotalLen
+		q.ID = h.ID
+		return q, b[h.Len:], nil
+	case 6:
+		if len(b) < ipv6.HeaderLen {
+			return nil, nil, errNotQuoted
+		}
+		q.TOS = int(binary.BigEndian.Uint16(b[0:2])>>4) & 0xff
+		q.ID = int(binary.BigEndian.Uint32(b[0:4]) & 0xfffff) // flow label
+		q.Length = int(binary.BigEndian.Uint16(b[4:6])) + ipv6.HeaderLen
+		q.TTL = int(b[7])
+		q.Src, _ = netip.AddrFromSlice(b[8:24])
+		q.Dst, _ = netip.AddrFromSlice(b[24:40])
+		next := int(b[6])
+		b = b[ipv6.HeaderLen:]
+		// skip extension headers
+		for {
+			switch next {
+			case 0, 43, 60: // hop-by-hop, routing, destination options
+				if len(b) < 8 {
+					return nil, nil, errNotQuoted
+				}
+				l := (int(b[1]) + 1) * 8
+				if len(b) < l {
+					return nil, nil, errNotQuoted
+				}
+				next = int(b[0])
+				b = b[l:]
+			case 44: // fragment
+				if len(b) < 8 || binary.BigEndian.Uint16(b[2:4])&0xfff8 != 0 {
+					return nil, nil, errNotQuoted // not the first fragment
+				}
+				next = int(b[0])
+				b = b[8:]
+			default:
+				q.Protocol = next
+				return q, b, nil
+			}
+		}
+	default:
+		return nil, nil, errNotQuoted
+	}
+}
+
+// parseEchoRequest returns the echo request in the transport part of a quoted datagram or nil
+func parseEchoRequest(protocol int, b []byte) *icmp.Echo {
+	var expected icmp.Type
+	switch protocol {
+	case iana.ProtocolICMP:
+		expected = ipv4.ICMPTypeEcho
+	case iana.ProtocolIPv6ICMP:
+		expected = ipv6.ICMPTypeEchoRequest
+	default:
+		return nil
+	}
+	m, err := icmp.ParseMessage(protocol, b)
+	if err != nil {
+		return nil
+	}
+	e, ok := m.Body.(*icmp.Echo)
+	if !ok || m.Type != expected {
+		return nil
+	}
+	return e
+}
+
+// ports returns the source and destination ports of the quoted UDP or TCP header
+func (r *reply) ports() (src, dst int, ok bool) {
+	if r.protocol != iana.ProtocolUDP && r.protocol != iana.ProtocolTCP || len(r.transport) < 4 {
+		return 0, 0, false
+	}
+	return int(binary.BigEndian.Uint16(r.transport[0:2])), int(binary.BigEndian.Uint16(r.transport[2:4])), true
+}
+
+// matches returns true if r is about an echo request with this id and token sent to dst.
+// The token is only checked if the echo payload is long enough to hold it
+// (ICMPv4 errors usually quote only 8 bytes after the IP header).
+func (r *reply) matches(id int, token []byte, dst netip.Addr) bool {
+	if r.echo == nil || r.echo.ID != id {
+		return false
+	}
+	if r.dst.IsValid() && r.dst.Unmap() != dst {
+		return false
+	}
+	if len(r.echo.Data) >= len(token) && !bytes.Equal(r.echo.Data[:len(token)], token) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,146 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ping
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"nspeed.app/nspeed/iana"
+)
+
+// marshal returns the wire format of an ICMP message
+func marshal(t *testing.T, typ icmp.Type, body icmp.MessageBody) []byte {
+	t.Helper()
+	b, err := (&icmp.Message{Type: typ, Body: body}).Marshal(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestParseReplyIPv4(t *testing.T) {
+	token := []byte("12345678")
+	dst := netip.MustParseAddr("192.0.2.1")
+	request := marshal(t, ipv4.ICMPTypeEcho, &icmp.Echo{ID: 42, Seq: 7, Data: payload(64, token)})
+	h := ipv4.Header{Version: 4, Len: ipv4.HeaderLen, TotalLen: ipv4.HeaderLen + len(request), TTL: 1,
+		Protocol: iana.ProtocolICMP, Src: net.IPv4(192, 0, 2, 2), Dst: dst.AsSlice()}
+	hb, err := h.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	quoted := append(hb, request[:8]...) // RFC 792: only 8 bytes of the original datagram
+	h.Protocol = iana.ProtocolUDP
+	if hb, err = h.Marshal(); err != nil {
+		t.Fatal(err)
+	}
+	udpQuoted := append(hb, 0x80, 0x01, 0x82, 0x9a, 0, 8, 0, 0) // 32769 -> 33434
+
+	tests := []struct {
+		name    string
+		message []byte
+		id      int
+		token   []byte
+		want    bool
+		wantErr bool
+	}{
+		{"reply", marshal(t, ipv4.ICMPTypeEchoReply, &icmp.Echo{ID: 42, Seq: 7, Data: payload(64, token)}), 42, token, true, false},
+		{"reply other id", marshal(t, ipv4.ICMPTypeEchoReply, &icmp.Echo{ID: 43, Seq: 7, Data: payload(64, token)}), 42, token, false, false},
+		{"reply other token", marshal(t, ipv4.ICMPTypeEchoReply, &icmp.Echo{ID: 42, Seq: 7, Data: payload(64, []byte("87654321"))}), 42, token, false, false},
+		{"time exceeded", marshal(t, ipv4.ICMPTypeTimeExceeded, &icmp.TimeExceeded{Data: quoted}), 42, token, true, false},
+		{"unreachable other id", marshal(t, ipv4.ICMPTypeDestinationUnreachable, &icmp.DstUnreach{Data: quoted}), 41, token, false, false},
+		{"request", request, 42, token, false, true},
+		{"unreachable udp", marshal(t, ipv4.ICMPTypeDestinationUnreachable, &icmp.DstUnreach{Data: udpQuoted}), 42, token, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := parseReply(iana.ProtocolICMP, tt.message)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseReply() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if r.echo != nil && r.echo.Seq != 7 {
+				t.Errorf("Seq = %d, want 7", r.echo.Seq)
+			}
+			if got := r.matches(tt.id, tt.token, dst); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseReplyIPv6(t *testing.T) {
+	token := []byte("12345678")
+	dst := netip.MustParseAddr("2001:db8::1")
+	request := marshal(t, ipv6.ICMPTypeEchoRequest, &icmp.Echo{ID: 42, Seq: 9, Data: payload(64, token)})
+	quoted := make([]byte, ipv6.HeaderLen)
+	quoted[0] = 6 << 4
+	quoted[6] = iana.ProtocolIPv6ICMP
+	copy(quoted[24:40], dst.AsSlice())
+	quoted = append(quoted, request...)
+
+	message := marshal(t, ipv6.ICMPTypePacketTooBig, &icmp.PacketTooBig{MTU: 1280, Data: quoted})
+	r, err := parseReply(iana.ProtocolIPv6ICMP, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.dst != dst || r.echo.Seq != 9 {
+		t.Errorf("dst, Seq = %v, %d want %v, 9", r.dst, r.echo.Seq, dst)
+	}
+	if !r.matches(42, token, dst) {
+		t.Error("matches() = false, want true")
+	}
+	if r.matches(42, token, netip.MustParseAddr("2001:db8::2")) {
+		t.Error("matches() other destination = true, want false")
+	}
+}
+
+func TestReplyPorts(t *testing.T) {
+	h := ipv4.Header{Version: 4, Len: ipv4.HeaderLen, TotalLen: ipv4.HeaderLen + 8, TTL: 1,
+		Protocol: iana.ProtocolUDP, Src: net.IPv4(192, 0, 2, 2), Dst: net.IPv4(192, 0, 2, 1)}
+	hb, err := h.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	quoted := append(hb, 0x80, 0x01, 0x82, 0x9a, 0, 8, 0, 0)
+	r, err := parseReply(iana.ProtocolICMP, marshal(t, ipv4.ICMPTypeTimeExceeded, &icmp.TimeExceeded{Data: quoted}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, dst, ok := r.ports()
+	if !ok || src != 32769 || dst != 33434 {
+		t.Errorf("ports() = %d, %d, %v want 32769, 33434, true", src, dst, ok)
+	}
+	if r.echo != nil {
+		t.Error("echo should be nil")
+	}
+}
+
+func TestReplyMTU(t *testing.T) {
+	dst := netip.MustParseAddr("192.0.2.1")
+	request := marshal(t, ipv4.ICMPTypeEcho, &icmp.Echo{ID: 42, Seq: 7})
+	h := ipv4.Header{Version: 4, Len: ipv4.HeaderLen, TotalLen: 1500, TTL: 1,
+		Protocol: iana.ProtocolICMP, Src: net.IPv4(192, 0, 2, 2), Dst: dst.AsSlice()}
+	hb, err := h.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := marshal(t, ipv4.ICMPTypeDestinationUnreachable, &icmp.DstUnreach{Data: append(hb, request[:8]...)})
+	message[1] = 4                      // code: fragmentation needed
+	message[6], message[7] = 0x05, 0xb4 // next-hop MTU: 1460
+	r, err := parseReply(iana.ProtocolICMP, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.mtu != 1460 {
+		t.Errorf("mtu = %d, want 1460", r.mtu)
+	}
+}
@@ -0,0 +1,29 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build darwin || freebsd
+
+package ping
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// setDontFragment sets the Don't Fragment bit (IPv4) and disables the local fragmentation (IPv6)
+func setDontFragment(rc syscall.RawConn, isIPv4 bool) error {
+	var err error
+	cerr := rc.Control(func(fd uintptr) {
+		if isIPv4 {
+			err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_DONTFRAG, 1)
+		} else {
+			err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_DONTFRAG, 1)
+		}
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
@@ -0,0 +1,28 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ping
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// setDontFragment sets the Don't Fragment bit and ignores the cached path MTU (PMTUDISC_PROBE),
+// so packets up to the interface MTU can be sent.
+func setDontFragment(rc syscall.RawConn, isIPv4 bool) error {
+	var err error
+	cerr := rc.Control(func(fd uintptr) {
+		if isIPv4 {
+			err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, unix.IP_PMTUDISC_PROBE)
+		} else {
+			err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_MTU_DISCOVER, unix.IPV6_PMTUDISC_PROBE)
+		}
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
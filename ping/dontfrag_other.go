@@ -0,0 +1,17 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !linux && !darwin && !freebsd
+
+package ping
+
+import (
+	"errors"
+	"syscall"
+)
+
+// setDontFragment is not supported on this platform
+func setDontFragment(rc syscall.RawConn, isIPv4 bool) error {
+	return errors.New("don't fragment is not supported on this platform")
+}
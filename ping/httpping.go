@@ -0,0 +1,132 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ping
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"nspeed.app/nspeed/network"
+)
+
+// HTTPTiming is the time split of an HTTP probe.
+// Durations of steps which didn't happen (DNS for a literal address, TLS for http, all of them for a reused connection) are 0.
+type HTTPTiming struct {
+	DNS        time.Duration // DNS resolution
+	Connect    time.Duration // TCP handshake
+	TLS        time.Duration // TLS handshake
+	TTFB       time.Duration // from the request written to the first byte of the response
+	Total      time.Duration // from the start to the first byte of the response
+	StatusCode int           // HTTP status code of the response
+	Reused     bool          // the connection was reused
+}
+
+// HTTPProber is a Prober measuring the latency of an HTTP(S) request.
+type HTTPProber struct {
+	URL       string
+	Options   PingOptions // only Version, Timeout and TrafficClass are used
+	Method    string      // HTTP method, "" = GET
+	Reuse     bool        // reuse the connection between probes (keep-alive)
+	TLSConfig *tls.Config // optional TLS configuration
+
+	once      sync.Once
+	transport *http.Transport
+}
+
+// NewHTTPProber returns a Prober requesting url
+func NewHTTPProber(url string, options PingOptions) *HTTPProber {
+	return &HTTPProber{URL: url, Options: options}
+}
+
+// Probe performs a single HTTP request. Only the headers of the response are read.
+// Any HTTP response (whatever its status code) is a PingResponseEchoReply.
+// The RTT of the result is HTTPTiming.Total.
+func (p *HTTPProber) Probe(ctx context.Context) (Result, error) {
+	p.once.Do(func() {
+		dialer := &net.Dialer{}
+		if p.Options.TrafficClass != 0 {
+			dialer.Control = network.TrafficClassControl(p.Options.TrafficClass)
+		}
+		p.transport = &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: func(ctx context.Context, nw, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network.AddIPVersionToNetwork("tcp", network.IPVersion(p.Options.Version)), addr)
+			},
+			TLSClientConfig:   p.TLSConfig,
+			DisableKeepAlives: !p.Reuse,
+			ForceAttemptHTTP2: true,
+		}
+	})
+
+	ctx, cancel := withTimeout(ctx, p.Options.Timeout)
+	defer cancel()
+
+	var timing HTTPTiming
+	var result Result
+	var start, dnsStart, connectStart, tlsStart, wrote time.Time
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:  func(httptrace.DNSDoneInfo) { timing.DNS = time.Since(dnsStart) },
+		ConnectStart: func(string, string) {
+			connectStart = time.Now()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil && timing.Connect == 0 {
+				timing.Connect = time.Since(connectStart)
+			}
+		},
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { timing.TLS = time.Since(tlsStart) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			timing.Reused = info.Reused
+			result.Peer = info.Conn.RemoteAddr()
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) { wrote = time.Now() },
+		GotFirstResponseByte: func() {
+			now := time.Now()
+			timing.TTFB = now.Sub(wrote)
+			timing.Total = now.Sub(start)
+		},
+	}
+
+	method := p.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), method, p.URL, nil)
+	if err != nil {
+		return Result{}, fmt.Errorf("new request error: %w", err)
+	}
+	start = time.Now()
+	resp, err := p.transport.RoundTrip(req)
+	if err != nil {
+		return Result{}, err
+	}
+	timing.StatusCode = resp.StatusCode
+	if p.Reuse {
+		// a small body can be drained to keep the connection
+		_, _ = io.CopyN(io.Discard, resp.Body, 4096)
+	}
+	_ = resp.Body.Close()
+
+	result.RTT = timing.Total
+	result.Response = PingResponseEchoReply
+	result.HTTP = &timing
+	return result, nil
+}
+
+// Close closes the idle connections of the prober
+func (p *HTTPProber) Close() {
+	if p.transport != nil {
+		p.transport.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ping
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// ICMPInfo is the decoded ICMP message of a response
+type ICMPInfo struct {
+	Version int           // IP version of the message: 4 (ICMP) or 6 (ICMPv6)
+	Type    int           // raw ICMP type
+	Code    int           // raw ICMP code
+	Reason  string        // decoded type and code, "port unreachable", "communication administratively prohibited", ...
+	From    netip.Addr    // host or router which sent the message
+	MTU     int           // next-hop MTU of a Packet Too Big or Fragmentation Needed error, 0 otherwise
+	Quoted  *QuotedHeader // header of the original datagram quoted in an ICMP error, nil for an echo reply
+}
+
+// QuotedHeader is the IP header of the original datagram quoted in an ICMP error.
+// As seen by the router which sent the error: the TTL is usually 0 or 1 for a Time Exceeded,
+// a difference with the sent datagram (TOS, length) shows a modification on the path.
+type QuotedHeader struct {
+	Version  int        // 4 or 6
+	Src      netip.Addr // source address
+	Dst      netip.Addr // destination address
+	Protocol int        // protocol (IPv6: next header after the extension headers)
+	TTL      int        // TTL or hop limit
+	TOS      int        // TOS or traffic class
+	Length   int        // total length of the datagram (not of the quoted part)
+	ID       int        // identification (IPv6: flow label)
+	Data     []byte     // the quoted datagram as received (header included)
+}
+
+// Prohibited reports whether the message is a Destination Unreachable caused by
+// a filter (firewall, ACL, ingress/egress policy) rather than a routing failure.
+func (i *ICMPInfo) Prohibited() bool {
+	if i.Version == 4 {
+		if i.Type != int(ipv4.ICMPTypeDestinationUnreachable) {
+			return false
+		}
+		switch i.Code {
+		case 9, 10, 13, 14, 15:
+			return true
+		}
+		return false
+	}
+	if i.Type != int(ipv6.ICMPTypeDestinationUnreachable) {
+		return false
+	}
+	switch i.Code {
+	case 1, 5, 6:
+		return true
+	}
+	return false
+}
+
+// NoRoute reports whether the message is a Destination Unreachable caused by
+// a routing failure: no route to the network or the host doesn't answer on its link.
+func (i *ICMPInfo) NoRoute() bool {
+	if i.Version == 4 {
+		if i.Type != int(ipv4.ICMPTypeDestinationUnreachable) {
+			return false
+		}
+		switch i.Code {
+		case 0, 1, 5, 6, 7, 11, 12:
+			return true
+		}
+		return false
+	}
+	if i.Type != int(ipv6.ICMPTypeDestinationUnreachable) {
+		return false
+	}
+	switch i.Code {
+	case 0, 2, 3:
+		return true
+	}
+	return false
+}
+
+func (i *ICMPInfo) String() string {
+	if i.MTU > 0 {
+		return fmt.Sprintf("%s (mtu %d) from %s", i.Reason, i.MTU, i.From)
+	}
+	return fmt.Sprintf("%s from %s", i.Reason, i.From)
+}
+
+// ICMP Destination Unreachable codes (RFC 792, RFC 1122, RFC 1812)
+var ipv4UnreachableReasons = []string{
+	0:  "network unreachable",
+	1:  "host unreachable",
+	2:  "protocol unreachable",
+	3:  "port unreachable",
+	4:  "fragmentation needed and DF set",
+	5:  "source route failed",
+	6:  "destination network unknown",
+	7:  "destination host unknown",
+	8:  "source host isolated",
+	9:  "network administratively prohibited",
+	10: "host administratively prohibited",
+	11: "network unreachable for TOS",
+	12: "host unreachable for TOS",
+	13: "communication administratively prohibited",
+	14: "host precedence violation",
+	15: "precedence cutoff in effect",
+}
+
+// ICMPv6 Destination Unreachable codes (RFC 4443, RFC 7112)
+var ipv6UnreachableReasons = []string{
+	0: "no route to destination",
+	1: "communication administratively prohibited",
+	2: "beyond scope of source address",
+	3: "address unreachable",
+	4: "port unreachable",
+	5: "source address failed ingress/egress policy",
+	6: "reject route to destination",
+	7: "error in source routing header",
+	8: "headers too long",
+}
+
+var ipv4TimeExceededReasons = []string{
+	0: "TTL exceeded in transit",
+	1: "fragment reassembly time exceeded",
+}
+
+var ipv6TimeExceededReasons = []string{
+	0: "hop limit exceeded in transit",
+	1: "fragment reassembly time exceeded",
+}
+
+var ipv4ParamProbReasons = []string{
+	0: "parameter problem",
+	1: "missing a required option",
+	2: "bad length",
+}
+
+// ICMPv6 Parameter Problem codes (RFC 4443, RFC 7112)
+var ipv6ParamProbReasons = []string{
+	0: "erroneous header field",
+	1: "unrecognized next header",
+	2: "unrecognized IPv6 option",
+	3: "incomplete header chain",
+}
+
+// icmpReason returns a description of an ICMP type and code
+func icmpReason(isIPv4 bool, typ, code int) string {
+	var reasons []string
+	var name string
+	if isIPv4 {
+		name = ipv4.ICMPType(typ).String()
+		switch ipv4.ICMPType(typ) {
+		case ipv4.ICMPTypeDestinationUnreachable:
+			reasons = ipv4UnreachableReasons
+		case ipv4.ICMPTypeTimeExceeded:
+			reasons = ipv4TimeExceededReasons
+		case ipv4.ICMPTypeParameterProblem:
+			reasons = ipv4ParamProbReasons
+		}
+	} else {
+		name = ipv6.ICMPType(typ).String()
+		switch ipv6.ICMPType(typ) {
+		case ipv6.ICMPTypeDestinationUnreachable:
+			reasons = ipv6UnreachableReasons
+		case ipv6.ICMPTypeTimeExceeded:
+			reasons = ipv6TimeExceededReasons
+		case ipv6.ICMPTypeParameterProblem:
+			reasons = ipv6ParamProbReasons
+		}
+	}
+	if code >= 0 && code < len(reasons) {
+		return reasons[code]
+	}
+	if name == "" || name == "<nil>" {
+		name = fmt.Sprintf("type %d", typ)
+	}
+	if reasons != nil || code != 0 {
+		return fmt.Sprintf("%s (code %d)", name, code)
+	}
+	return name
+}
+
+// info returns the decoded ICMP message of the reply received from peer
+func (r *reply) info(peer net.Addr) *ICMPInfo {
+	i := &ICMPInfo{Code: r.message.Code, MTU: r.mtu, Quoted: r.header}
+	switch t := r.message.Type.(type) {
+	case ipv4.ICMPType:
+		i.Version, i.Type = 4, int(t)
+	case ipv6.ICMPType:
+		i.Version, i.Type = 6, int(t)
+	}
+	i.Reason = icmpReason(i.Version == 4, i.Type, i.Code)
+	i.From, _ = peerAddr(peer)
+	return i
+}
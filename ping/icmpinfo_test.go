@@ -0,0 +1,97 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ping
+
+import (
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"nspeed.app/nspeed/iana"
+)
+
+func TestICMPInfo(t *testing.T) {
+	tests := []struct {
+		name       string
+		version    int
+		typ        int
+		code       int
+		reason     string
+		prohibited bool
+		noRoute    bool
+	}{
+		{"v4 echo reply", 4, int(ipv4.ICMPTypeEchoReply), 0, "echo reply", false, false},
+		{"v4 net unreachable", 4, int(ipv4.ICMPTypeDestinationUnreachable), 0, "network unreachable", false, true},
+		{"v4 host unreachable", 4, int(ipv4.ICMPTypeDestinationUnreachable), 1, "host unreachable", false, true},
+		{"v4 port unreachable", 4, int(ipv4.ICMPTypeDestinationUnreachable), 3, "port unreachable", false, false},
+		{"v4 frag needed", 4, int(ipv4.ICMPTypeDestinationUnreachable), 4, "fragmentation needed and DF set", false, false},
+		{"v4 admin prohibited", 4, int(ipv4.ICMPTypeDestinationUnreachable), 13, "communication administratively prohibited", true, false},
+		{"v4 host prohibited", 4, int(ipv4.ICMPTypeDestinationUnreachable), 10, "host administratively prohibited", true, false},
+		{"v4 unknown code", 4, int(ipv4.ICMPTypeDestinationUnreachable), 16, "destination unreachable (code 16)", false, false},
+		{"v4 ttl exceeded", 4, int(ipv4.ICMPTypeTimeExceeded), 0, "TTL exceeded in transit", false, false},
+		{"v4 unknown type", 4, 99, 0, "type 99", false, false},
+		{"v6 no route", 6, int(ipv6.ICMPTypeDestinationUnreachable), 0, "no route to destination", false, true},
+		{"v6 admin prohibited", 6, int(ipv6.ICMPTypeDestinationUnreachable), 1, "communication administratively prohibited", true, false},
+		{"v6 address unreachable", 6, int(ipv6.ICMPTypeDestinationUnreachable), 3, "address unreachable", false, true},
+		{"v6 port unreachable", 6, int(ipv6.ICMPTypeDestinationUnreachable), 4, "port unreachable", false, false},
+		{"v6 policy", 6, int(ipv6.ICMPTypeDestinationUnreachable), 5, "source address failed ingress/egress policy", true, false},
+		{"v6 reject route", 6, int(ipv6.ICMPTypeDestinationUnreachable), 6, "reject route to destination", true, false},
+		{"v6 packet too big", 6, int(ipv6.ICMPTypePacketTooBig), 0, "packet too big", false, false},
+		{"v6 hop limit", 6, int(ipv6.ICMPTypeTimeExceeded), 0, "hop limit exceeded in transit", false, false},
+		{"v6 header chain", 6, int(ipv6.ICMPTypeParameterProblem), 3, "incomplete header chain", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &ICMPInfo{Version: tt.version, Type: tt.typ, Code: tt.code}
+			if got := icmpReason(tt.version == 4, tt.typ, tt.code); got != tt.reason {
+				t.Errorf("icmpReason() = %q, want %q", got, tt.reason)
+			}
+			if got := i.Prohibited(); got != tt.prohibited {
+				t.Errorf("Prohibited() = %v, want %v", got, tt.prohibited)
+			}
+			if got := i.NoRoute(); got != tt.noRoute {
+				t.Errorf("NoRoute() = %v, want %v", got, tt.noRoute)
+			}
+		})
+	}
+}
+
+func TestReplyInfo(t *testing.T) {
+	dst := netip.MustParseAddr("192.0.2.1")
+	request := marshal(t, ipv4.ICMPTypeEcho, &icmp.Echo{ID: 42, Seq: 7})
+	h := ipv4.Header{Version: 4, Len: ipv4.HeaderLen, TotalLen: 1500, TTL: 1, TOS: 0xb8, ID: 1234,
+		Protocol: iana.ProtocolICMP, Src: net.IPv4(192, 0, 2, 2), Dst: dst.AsSlice()}
+	hb, err := h.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := marshal(t, ipv4.ICMPTypeDestinationUnreachable, &icmp.DstUnreach{Data: append(hb, request[:8]...)})
+	message[1] = 13 // code: communication administratively prohibited
+	r, err := parseReply(iana.ProtocolICMP, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := r.info(&net.IPAddr{IP: net.IPv4(198, 51, 100, 1)})
+	if i.Version != 4 || i.Type != 3 || i.Code != 13 || !i.Prohibited() {
+		t.Errorf("info() = %+v, want a v4 type 3 code 13 prohibited", i)
+	}
+	if i.From != netip.MustParseAddr("198.51.100.1") {
+		t.Errorf("From = %v, want 198.51.100.1", i.From)
+	}
+	q := i.Quoted
+	if q == nil {
+		t.Fatal("Quoted = nil")
+	}
+	want := QuotedHeader{Version: 4, Src: netip.MustParseAddr("192.0.2.2"), Dst: dst, Protocol: iana.ProtocolICMP,
+		TTL: 1, TOS: 0xb8, Length: 1500, ID: 1234}
+	want.Data = q.Data
+	if !reflect.DeepEqual(*q, want) {
+		t.Errorf("Quoted = %+v, want %+v", *q, want)
+	}
+}
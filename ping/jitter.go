@@ -0,0 +1,116 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ping
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"time"
+)
+
+// HistogramBounds are the upper bounds (inclusive) of the round trip time histogram of Jitter.
+// A last bucket counts the greater values.
+var HistogramBounds = []time.Duration{
+	1 * time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	1000 * time.Millisecond,
+}
+
+// HistogramBucket is a bucket of a round trip time histogram
+type HistogramBucket struct {
+	Max   time.Duration // upper bound (inclusive), math.MaxInt64 for the last bucket
+	Count int
+}
+
+// Jitter are the delay variation statistics of a series of round trip times, in order of arrival.
+// The zero value is ready to use, see Add. Statistics include a Jitter.
+// All the round trip times are kept to compute the percentiles.
+type Jitter struct {
+	// Interarrival is the RFC 3550 interarrival jitter: the smoothed (1/16 gain) absolute
+	// difference between consecutive round trip times, as reported by RTP receivers.
+	Interarrival time.Duration
+	IPDV         time.Duration // mean absolute IP packet delay variation between consecutive round trip times (RFC 3393)
+	MaxIPDV      time.Duration // maximum absolute IP packet delay variation
+	P50          time.Duration // median round trip time
+	P90          time.Duration // 90th percentile of the round trip times
+	P99          time.Duration // 99th percentile of the round trip times
+	Histogram    []HistogramBucket
+
+	sorted  []time.Duration // round trip times in ascending order
+	last    time.Duration
+	sumIPDV float64
+	j       float64 // RFC 3550 jitter in ns
+}
+
+// Add records the round trip time of a reply
+func (j *Jitter) Add(rtt time.Duration) {
+	if n := len(j.sorted); n > 0 {
+		d := math.Abs(float64(rtt - j.last))
+		// RFC 3550 6.4.1: J(i) = J(i-1) + (|D(i-1,i)| - J(i-1))/16
+		j.j += (d - j.j) / 16
+		j.Interarrival = time.Duration(j.j)
+		j.sumIPDV += d
+		j.IPDV = time.Duration(j.sumIPDV / float64(n))
+		j.MaxIPDV = max(j.MaxIPDV, time.Duration(d))
+	}
+	j.last = rtt
+
+	i, _ := slices.BinarySearch(j.sorted, rtt)
+	j.sorted = slices.Insert(j.sorted, i, rtt)
+	j.P50 = j.Percentile(50)
+	j.P90 = j.Percentile(90)
+	j.P99 = j.Percentile(99)
+
+	if j.Histogram == nil {
+		j.Histogram = make([]HistogramBucket, len(HistogramBounds)+1)
+		for k, b := range HistogramBounds {
+			j.Histogram[k].Max = b
+		}
+		j.Histogram[len(HistogramBounds)].Max = math.MaxInt64
+	}
+	for k := range j.Histogram {
+		if rtt <= j.Histogram[k].Max {
+			j.Histogram[k].Count++
+			break
+		}
+	}
+}
+
+// clone returns a copy of j which doesn't share its round trip times and histogram,
+// Add updates them in place
+func (j Jitter) clone() Jitter {
+	j.sorted = slices.Clone(j.sorted)
+	j.Histogram = slices.Clone(j.Histogram)
+	return j
+}
+
+// Count returns the number of recorded round trip times
+func (j Jitter) Count() int {
+	return len(j.sorted)
+}
+
+// Percentile returns the pth percentile (0 < p <= 100) of the round trip times
+// with the nearest-rank method, 0 if there are none.
+func (j Jitter) Percentile(p float64) time.Duration {
+	n := len(j.sorted)
+	if n == 0 || p <= 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(n)))
+	return j.sorted[min(max(rank, 1), n)-1]
+}
+
+func (j Jitter) String() string {
+	return fmt.Sprintf("jitter %.3f ms, ipdv avg/max = %.3f/%.3f ms, rtt p50/p90/p99 = %.3f/%.3f/%.3f ms",
+		ms(j.Interarrival), ms(j.IPDV), ms(j.MaxIPDV), ms(j.P50), ms(j.P90), ms(j.P99))
+}
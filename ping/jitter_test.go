@@ -0,0 +1,70 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ping
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJitter(t *testing.T) {
+	var j Jitter
+	for _, rtt := range []time.Duration{10, 20, 15, 15, 30} {
+		j.Add(rtt * time.Millisecond)
+	}
+	if j.Count() != 5 {
+		t.Errorf("Count() = %d, want 5", j.Count())
+	}
+	// |D| = 10, 5, 0, 15 ms, J += (|D| - J)/16
+	if d := j.Interarrival - 1727142*time.Nanosecond; d < -time.Microsecond || d > time.Microsecond {
+		t.Errorf("Interarrival = %v, want ~1.727ms", j.Interarrival)
+	}
+	if j.IPDV != 7500*time.Microsecond || j.MaxIPDV != 15*time.Millisecond {
+		t.Errorf("IPDV, MaxIPDV = %v, %v want 7.5ms, 15ms", j.IPDV, j.MaxIPDV)
+	}
+	if j.P50 != 15*time.Millisecond || j.P90 != 30*time.Millisecond || j.P99 != 30*time.Millisecond {
+		t.Errorf("P50/P90/P99 = %v/%v/%v, want 15ms/30ms/30ms", j.P50, j.P90, j.P99)
+	}
+	want := map[time.Duration]int{10 * time.Millisecond: 1, 20 * time.Millisecond: 3, 50 * time.Millisecond: 1}
+	if len(j.Histogram) != len(HistogramBounds)+1 {
+		t.Fatalf("%d buckets, want %d", len(j.Histogram), len(HistogramBounds)+1)
+	}
+	for _, b := range j.Histogram {
+		if b.Count != want[b.Max] {
+			t.Errorf("bucket %v: %d, want %d", b.Max, b.Count, want[b.Max])
+		}
+	}
+}
+
+func TestJitterPercentile(t *testing.T) {
+	var j Jitter
+	if j.Percentile(50) != 0 {
+		t.Errorf("Percentile(50) of no samples = %v, want 0", j.Percentile(50))
+	}
+	for i := 100; i > 0; i-- {
+		j.Add(time.Duration(i) * time.Millisecond)
+	}
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{1, 1 * time.Millisecond},
+		{50, 50 * time.Millisecond},
+		{90, 90 * time.Millisecond},
+		{99.5, 100 * time.Millisecond},
+		{100, 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := j.Percentile(tt.p); got != tt.want {
+			t.Errorf("Percentile(%v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+	// a clone doesn't change, Add updates j in place
+	c := j.clone()
+	j.Add(0)
+	if c.Percentile(1) != time.Millisecond || c.Count() != 100 {
+		t.Errorf("clone changed: Percentile(1) = %v, Count() = %d", c.Percentile(1), c.Count())
+	}
+}
@@ -7,18 +7,17 @@
 package ping
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"net"
-	"os"
-	"sync"
+	"net/netip"
 	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/icmp"
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
-	"nspeed.app/nspeed/iana"
 	"nspeed.app/nspeed/network"
 )
 
@@ -27,6 +26,13 @@ type PingOptions struct {
 	Version    int           // IP version: 0,4 or 6
 	PacketSize uint16        // size of ping packet up to PacketSizeMax
 	Timeout    time.Duration // timeout , 0 = no timeout
+	Mode       SocketMode    // kind of ICMP socket: privileged (default), unprivileged or auto
+	// DontFragment sets the Don't Fragment bit (IPv4) and disables the local fragmentation (IPv4 & IPv6),
+	// packets bigger than the path MTU are dropped. Only for privileged sockets on Linux, Darwin and FreeBSD.
+	DontFragment bool
+	// TrafficClass is the DSCP and ECN marking of the sent packets (IPv4 TOS or IPv6 traffic class),
+	// see network.NewTrafficClass. 0 = not set (best effort).
+	TrafficClass network.TrafficClass
 }
 
 const PacketSizeMax = math.MaxUint16 // 64KB
@@ -59,82 +65,65 @@ func (p PingResponse) String() string {
 	return "hidden ping response"
 }
 
-// single shared buffer for sending.
-var sendBuffer []byte
-
-func allocBuffer() {
-	sync.OnceFunc(func() {
-		//fmt.Println("ALLOCATING")
-		sendBuffer = make([]byte, PacketSizeMax)
-	})()
-}
-
 var count atomic.Int32
 
 // Ping performs a single ICMP echo request to destination.
-// On Unix platforms requires root or cap_net_raw capability.
+// On Unix platforms requires root or cap_net_raw capability unless options.Mode is
+// SocketUnprivileged or SocketAuto (see SocketMode).
 //
-// This is not thread safe even at the OS level safe (a concurrent ping or mtr will impact the result)
-// the Seq doesn't seem to be used by the Go x/net/icmp package
+// The response is either the echo reply or an ICMP error (from the destination or a router)
+// about our echo request. Other ICMP messages (of concurrent pings or mtr for instance) are discarded
+// until the timeout.
 func Ping(destination string, options PingOptions) (peer net.Addr, ping time.Duration, response PingResponse, err error) {
+	r, err := pingOnce(context.Background(), destination, options)
+	return r.Peer, r.RTT, r.Response, err
+}
 
+// pingOnce performs a single ICMP echo request (see Ping) and returns a Result with the decoded ICMP response.
+// Canceling ctx interrupts the wait for the response.
+func pingOnce(ctx context.Context, destination string, options PingOptions) (Result, error) {
 	destAddr, err := network.Resolve(destination, options.Version)
 	if err != nil {
-		return
+		return Result{}, err
 	}
 	isIPv4 := destAddr.Is4() || destAddr.Is4In6()
 
 	// this should never arise but in case:
 	if isIPv4 && options.Version == 6 {
-		err = fmt.Errorf("IP version mismatch")
-		return
+		return Result{}, fmt.Errorf("IP version mismatch")
 	}
 
-	network := "ip6:ipv6-icmp"
-	laddr := "::"
-	var itype icmp.Type = ipv6.ICMPTypeEchoRequest
-	protonumber := iana.ProtocolIPv6ICMP
-	if isIPv4 {
-		network = "ip4:icmp"
-		laddr = "0.0.0.0"
-		itype = ipv4.ICMPTypeEcho
-		protonumber = iana.ProtocolICMP
-	}
-
-	c, err := icmp.ListenPacket(network, laddr)
+	c, err := listen(isIPv4, options)
 	if err != nil {
-		err = fmt.Errorf("listen packet error: %w", err)
-		return
+		return Result{}, err
 	}
 	defer func() {
 		_ = c.Close()
 	}()
 
-	// TTL
-	ttl := options.HopLimit
-
-	if ttl > 0 && isIPv4 {
-		err = c.IPv4PacketConn().SetTTL(ttl)
-	}
-	if ttl > 0 && !isIPv4 {
-		err = c.IPv6PacketConn().SetHopLimit(ttl)
-	}
-	if err != nil {
-		err = fmt.Errorf("error setting TTL: %w", err)
-		return
-	}
-
-	allocBuffer()
 	size := 0
 	if options.PacketSize > 0 {
 		size = int(options.PacketSize) - 1
 	}
+	id := c.echoID(nextID())
+	seq := int(count.Add(1)) & 0xffff
+	token := newToken()
+	peer, rtt, r, err := c.exchange(ctx, destAddr.Unmap(), id, seq, token, payload(size, token), options.Timeout)
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Peer: peer, RTT: rtt, Response: classify(r.message.Type), ICMP: r.info(peer)}, nil
+}
+
+// exchange sends an echo request with id, seq and data (starting with token) to dst
+// and waits for its reply or an ICMP error about it. A zero timeout waits forever, until ctx is canceled.
+func (c *conn) exchange(ctx context.Context, dst netip.Addr, id, seq int, token, data []byte, timeout time.Duration) (peer net.Addr, rtt time.Duration, r *reply, err error) {
 	wm := icmp.Message{
-		Type: itype, Code: 0,
+		Type: c.itype, Code: 0,
 		Body: &icmp.Echo{
-			ID:   os.Getpid() & 0xffff,
-			Seq:  int(count.Add(1)),
-			Data: sendBuffer[:size],
+			ID:   id,
+			Seq:  seq,
+			Data: data,
 		},
 	}
 	wb, err := wm.Marshal(nil)
@@ -143,43 +132,61 @@ func Ping(destination string, options PingOptions) (peer net.Addr, ping time.Dur
 		return
 	}
 
-	if options.Timeout > 0 {
-		_ = c.SetWriteDeadline(time.Now().Add(options.Timeout))
+	if timeout > 0 {
+		_ = c.SetWriteDeadline(time.Now().Add(timeout))
 	}
 
-	rb := make([]byte, 1500)
+	rb := make([]byte, PacketSizeMax)
 	start := time.Now()
-	if _, err = c.WriteTo(wb, &net.IPAddr{IP: destAddr.AsSlice()}); err != nil {
+	if _, err = c.writeTo(wb, dst); err != nil {
 		err = fmt.Errorf("WriteTo error: %w", err)
 		return
 	}
 
-	if options.Timeout > 0 {
-		_ = c.SetReadDeadline(time.Now().Add(options.Timeout))
-	}
-	n, peer, err := c.ReadFrom(rb)
-	if err != nil {
-		err = fmt.Errorf("read error: %w", err)
+	if timeout > 0 {
+		_ = c.SetReadDeadline(start.Add(timeout))
+	}
+	// canceling ctx interrupts the read
+	stop := context.AfterFunc(ctx, func() {
+		_ = c.SetReadDeadline(time.Now())
+	})
+	defer stop()
+	for {
+		var n int
+		n, peer, err = c.ReadFrom(rb)
+		if err != nil {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+				return
+			}
+			err = fmt.Errorf("read error: %w", err)
+			return
+		}
+		rtt = time.Since(start)
+		var perr error
+		r, perr = parseReply(c.protonumber, rb[:n])
+		if perr != nil || !r.matches(id, token, dst) || r.echo.Seq != seq {
+			continue // not ours
+		}
+		if !r.dst.IsValid() && !isFrom(peer, dst) {
+			continue // echo reply from another host
+		}
 		return
 	}
+}
 
-	ping = time.Since(start)
-	rm, err := icmp.ParseMessage(protonumber, rb[:n])
-	if err != nil {
-		err = fmt.Errorf("ParseMessage error: %w", err)
-		return
-	}
-	switch rm.Type {
+// classify maps an ICMP message type to a PingResponse
+func classify(t icmp.Type) PingResponse {
+	switch t {
 	case ipv6.ICMPTypeEchoReply, ipv4.ICMPTypeEchoReply:
-		response = PingResponseEchoReply
+		return PingResponseEchoReply
 	case ipv6.ICMPTypePacketTooBig:
-		response = PingResponsePacketTooBig
+		return PingResponsePacketTooBig
 	case ipv6.ICMPTypeDestinationUnreachable, ipv4.ICMPTypeDestinationUnreachable:
-		response = PingResponseDestinationUnreachable
+		return PingResponseDestinationUnreachable
 	case ipv6.ICMPTypeTimeExceeded, ipv4.ICMPTypeTimeExceeded:
-		response = PingResponseTimeExceeded
+		return PingResponseTimeExceeded
 	default: // eventually handle more
-		response = PingResponseNotHandled
+		return PingResponseNotHandled
 	}
-	return
 }
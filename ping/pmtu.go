@@ -0,0 +1,184 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"nspeed.app/nspeed/network"
+)
+
+// PMTUOptions are the options of DiscoverPathMTU
+type PMTUOptions struct {
+	Version int           // IP version: 0,4 or 6
+	Timeout time.Duration // time to wait for the response of a probe, 0 = DefaultTimeout
+	Retries int           // number of probes without response before a size is considered too big, 0 = DefaultPMTURetries
+	MinMTU  int           // smallest MTU, 0 = 68 (IPv4) or 1280 (IPv6)
+	MaxMTU  int           // biggest MTU, 0 = MTU of the outgoing interface
+}
+
+const DefaultPMTURetries = 2 // number of probes without response before a size is considered too big
+
+// PMTUResult is the result of DiscoverPathMTU
+type PMTUResult struct {
+	Destination netip.Addr
+	MTU         int        // path MTU: the biggest IP packet reaching the destination without fragmentation
+	Reporter    netip.Addr // last router which reported a smaller MTU (ICMP Packet Too Big / Fragmentation Needed), invalid if none
+	Probes      int        // number of probes sent
+}
+
+// DiscoverPathMTU discovers the path MTU to destination: it binary searches the size of
+// echo requests sent with the Don't Fragment bit set, using the next-hop MTU reported
+// by routers in ICMP errors to speed up the search.
+// A size without response after options.Retries probes is considered too big (PMTU black hole).
+//
+// It requires root or cap_net_raw capability and is only supported on Linux, Darwin and FreeBSD.
+func DiscoverPathMTU(destination string, options PMTUOptions) (*PMTUResult, error) {
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultTimeout
+	}
+	if options.Retries <= 0 {
+		options.Retries = DefaultPMTURetries
+	}
+	destAddr, err := network.Resolve(destination, options.Version)
+	if err != nil {
+		return nil, err
+	}
+	isIPv4 := destAddr.Is4() || destAddr.Is4In6()
+	if isIPv4 && options.Version == 6 {
+		return nil, fmt.Errorf("IP version mismatch")
+	}
+	destAddr = destAddr.Unmap()
+	header := ipv6.HeaderLen
+	if isIPv4 {
+		header = ipv4.HeaderLen
+	}
+	if options.MinMTU <= 0 {
+		options.MinMTU = 1280
+		if isIPv4 {
+			options.MinMTU = 68
+		}
+	}
+	if options.MaxMTU <= 0 {
+		iface, _, _, err := network.GetRoute(destAddr.String())
+		if err != nil {
+			return nil, err
+		}
+		options.MaxMTU = iface.MTU
+	}
+	options.MaxMTU = min(options.MaxMTU, math.MaxUint16)
+	if options.MinMTU > options.MaxMTU {
+		return nil, fmt.Errorf("min MTU (%d) is greater than max MTU (%d)", options.MinMTU, options.MaxMTU)
+	}
+
+	c, err := listen(isIPv4, PingOptions{Mode: SocketPrivileged, DontFragment: true})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+
+	result := &PMTUResult{Destination: destAddr}
+	id := nextID()
+	token := newToken()
+
+	// probe returns true if an echo request of size bytes (IP packet) reaches the destination
+	// and the next-hop MTU if a smaller one is reported.
+	probe := func(size int) (bool, int, error) {
+		for range options.Retries {
+			result.Probes++
+			seq := int(count.Add(1)) & 0xffff
+			data := payload(max(size-header-8, 0), token)
+			peer, _, r, err := c.exchange(context.Background(), destAddr, id, seq, token, data, options.Timeout)
+			if errors.Is(err, syscall.EMSGSIZE) {
+				return false, 0, nil // bigger than the local MTU
+			}
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				continue
+			}
+			if err != nil {
+				return false, 0, err
+			}
+			reached, mtu, err := pmtuResponse(r)
+			if err != nil {
+				return false, 0, fmt.Errorf("%w from %s", err, peer)
+			}
+			if !reached {
+				result.Reporter, _ = peerAddr(peer)
+			}
+			return reached, mtu, nil
+		}
+		return false, 0, nil
+	}
+
+	mtu, reached, err := searchPathMTU(options.MinMTU, options.MaxMTU, probe)
+	if err != nil {
+		return nil, err
+	}
+	if !reached {
+		return nil, fmt.Errorf("no response from %s with the minimum MTU (%d)", destAddr, options.MinMTU)
+	}
+	result.MTU = mtu
+	return result, nil
+}
+
+// pmtuResponse interprets the response r to a probe: reached for an echo reply, else the next-hop MTU
+// of a Packet Too Big or Fragmentation Needed error, 0 if not reported (routers before RFC 1191 or bogus).
+// The other errors are returned.
+func pmtuResponse(r *reply) (reached bool, mtu int, err error) {
+	switch {
+	case classify(r.message.Type) == PingResponseEchoReply:
+		return true, 0, nil
+	case r.message.Type == ipv6.ICMPTypePacketTooBig,
+		r.message.Type == ipv4.ICMPTypeDestinationUnreachable && r.message.Code == 4: // fragmentation needed
+		return false, r.mtu, nil
+	default:
+		return false, 0, errors.New(classify(r.message.Type).String())
+	}
+}
+
+// searchPathMTU binary searches the path MTU between lo and hi. probe returns true if an IP packet
+// of size bytes reaches the destination, else the next-hop MTU if a smaller one is reported (0 if not).
+// reached is false if lo doesn't reach the destination.
+func searchPathMTU(lo, hi int, probe func(size int) (bool, int, error)) (mtu int, reached bool, err error) {
+	verified := false // a probe reached the destination
+	candidate := hi   // the path MTU is often the local one
+	for lo < hi {
+		ok, reported, err := probe(candidate)
+		if err != nil {
+			return 0, false, err
+		}
+		if ok {
+			lo = candidate
+			verified = true
+		} else {
+			hi = candidate - 1
+			if reported >= lo && reported < candidate {
+				// the reported MTU is the most likely answer
+				hi = reported
+				candidate = reported
+				continue
+			}
+		}
+		candidate = (lo + hi + 1) / 2
+	}
+	if !verified {
+		ok, _, err := probe(lo)
+		if err != nil || !ok {
+			return 0, false, err
+		}
+	}
+	return lo, true, nil
+}
@@ -0,0 +1,138 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ping
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"net"
+	"net/netip"
+	"testing"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"nspeed.app/nspeed/iana"
+)
+
+func TestSearchPathMTU(t *testing.T) {
+	tests := []struct {
+		name       string
+		lo, hi     int
+		path       int // path MTU
+		reported   int // next-hop MTU reported by the router, 0 for a black hole
+		want       int // 0 if the destination isn't reached
+		wantProbes int // 0 to not check
+	}{
+		{"local MTU", 68, 1500, 1500, 0, 1500, 1},
+		{"above max", 68, 1500, 9000, 0, 1500, 1},
+		{"black hole", 68, 1500, 1400, 0, 1400, 0},
+		{"reported", 68, 1500, 1400, 1400, 1400, 2},
+		{"reported below min", 1280, 1500, 1400, 576, 1400, 0},
+		{"reported wrong", 68, 1500, 1000, 1400, 1000, 0},
+		{"min MTU", 68, 1500, 68, 0, 68, 0},
+		{"below min", 1280, 1500, 576, 576, 0, 0},
+		{"single size", 1280, 1280, 1500, 0, 1280, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			probes := 0
+			probe := func(size int) (bool, int, error) {
+				probes++
+				if size < tt.lo || size > tt.hi {
+					t.Fatalf("probe(%d) out of [%d, %d]", size, tt.lo, tt.hi)
+				}
+				if size <= tt.path {
+					return true, 0, nil
+				}
+				return false, tt.reported, nil
+			}
+			mtu, reached, err := searchPathMTU(tt.lo, tt.hi, probe)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reached != (tt.want != 0) || (reached && mtu != tt.want) {
+				t.Errorf("searchPathMTU() = %d, %v want %d", mtu, reached, tt.want)
+			}
+			if tt.wantProbes != 0 && probes != tt.wantProbes {
+				t.Errorf("%d probes, want %d", probes, tt.wantProbes)
+			}
+			if probes > 2+bits.Len(uint(tt.hi-tt.lo)) {
+				t.Errorf("%d probes, the search doesn't converge", probes)
+			}
+		})
+	}
+
+	errProbe := errors.New("probe error")
+	_, _, err := searchPathMTU(68, 1500, func(int) (bool, int, error) { return false, 0, errProbe })
+	if !errors.Is(err, errProbe) {
+		t.Errorf("searchPathMTU() error = %v, want %v", err, errProbe)
+	}
+}
+
+func TestPMTUResponse(t *testing.T) {
+	dst := netip.MustParseAddr("192.0.2.1")
+	request := marshal(t, ipv4.ICMPTypeEcho, &icmp.Echo{ID: 42, Seq: 7})
+	h := ipv4.Header{Version: 4, Len: ipv4.HeaderLen, TotalLen: 1500, TTL: 64,
+		Protocol: iana.ProtocolICMP, Src: net.IPv4(192, 0, 2, 2), Dst: dst.AsSlice()}
+	hb, err := h.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	quoted := append(hb, request[:8]...)
+	// unreachable returns a Destination Unreachable message with code and next-hop MTU
+	unreachable := func(code byte, mtu uint16) []byte {
+		m := marshal(t, ipv4.ICMPTypeDestinationUnreachable, &icmp.DstUnreach{Data: quoted})
+		m[1] = code
+		binary.BigEndian.PutUint16(m[6:8], mtu)
+		return m
+	}
+	tests := []struct {
+		name        string
+		protocol    int
+		message     []byte
+		wantReached bool
+		wantMTU     int
+		wantErr     bool
+	}{
+		{"echo reply", iana.ProtocolICMP, marshal(t, ipv4.ICMPTypeEchoReply, &icmp.Echo{ID: 42, Seq: 7}), true, 0, false},
+		{"fragmentation needed", iana.ProtocolICMP, unreachable(4, 1400), false, 1400, false},
+		{"fragmentation needed without MTU", iana.ProtocolICMP, unreachable(4, 0), false, 0, false},
+		{"host unreachable", iana.ProtocolICMP, unreachable(1, 0), false, 0, true},
+		{"packet too big without MTU", iana.ProtocolIPv6ICMP, marshal(t, ipv6.ICMPTypePacketTooBig, &icmp.PacketTooBig{MTU: 0, Data: quoted}), false, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := parseReply(tt.protocol, tt.message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reached, mtu, err := pmtuResponse(r)
+			if reached != tt.wantReached || mtu != tt.wantMTU || (err != nil) != tt.wantErr {
+				t.Errorf("pmtuResponse() = %v, %d, %v want %v, %d, error %v", reached, mtu, err, tt.wantReached, tt.wantMTU, tt.wantErr)
+			}
+		})
+	}
+
+	// the routers don't report the MTU: the search bisects
+	tooBig, err := parseReply(iana.ProtocolICMP, unreachable(4, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	echoReply, err := parseReply(iana.ProtocolICMP, marshal(t, ipv4.ICMPTypeEchoReply, &icmp.Echo{ID: 42, Seq: 7}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mtu, reached, err := searchPathMTU(68, 1500, func(size int) (bool, int, error) {
+		if size <= 1400 {
+			return pmtuResponse(echoReply)
+		}
+		return pmtuResponse(tooBig)
+	})
+	if err != nil || !reached || mtu != 1400 {
+		t.Errorf("searchPathMTU() = %d, %v, %v want 1400", mtu, reached, err)
+	}
+}
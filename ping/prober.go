@@ -0,0 +1,61 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ping
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// Prober measures the latency to a target with a single probe.
+// ICMPProber, TCPProber and HTTPProber implement it.
+type Prober interface {
+	Probe(ctx context.Context) (Result, error)
+}
+
+// Result is the result of a single probe
+type Result struct {
+	Peer     net.Addr      // address of the responder
+	RTT      time.Duration // round trip time
+	Response PingResponse  // kind of response
+
+	ICMP *ICMPInfo   // decoded ICMP response (type, code, reason, quoted header) of an ICMP probe, nil for other probes
+	HTTP *HTTPTiming // details of an HTTP probe, nil for other probes
+}
+
+// ICMPProber is a Prober sending an ICMP echo request (see Ping)
+type ICMPProber struct {
+	Destination string
+	Options     PingOptions
+}
+
+// NewICMPProber returns a Prober sending ICMP echo requests to destination
+func NewICMPProber(destination string, options PingOptions) *ICMPProber {
+	return &ICMPProber{Destination: destination, Options: options}
+}
+
+// Probe performs a single ICMP echo request.
+// The timeout is the earliest of options.Timeout and ctx deadline, canceling ctx interrupts the probe.
+func (p *ICMPProber) Probe(ctx context.Context) (Result, error) {
+	options := p.Options
+	if d, ok := ctx.Deadline(); ok {
+		if t := time.Until(d); options.Timeout == 0 || t < options.Timeout {
+			options.Timeout = t
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+	return pingOnce(ctx, p.Destination, options)
+}
+
+// withTimeout returns ctx with timeout if timeout > 0
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
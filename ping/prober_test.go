@@ -0,0 +1,94 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ping
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestTCPProber(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+
+	var p Prober = NewTCPProber(address, PingOptions{Timeout: time.Second})
+	r, err := p.Probe(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Response != PingResponseEchoReply || r.RTT <= 0 || r.Peer.String() != address {
+		t.Errorf("Probe() = %+v, want an echo reply from %s", r, address)
+	}
+
+	// closed port: the host answers with a RST
+	_ = l.Close()
+	r, err = p.Probe(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Response != PingResponseDestinationUnreachable {
+		t.Errorf("Probe() response = %v, want %v", r.Response, PingResponseDestinationUnreachable)
+	}
+}
+
+func TestHTTPProber(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	p := NewHTTPProber(ts.URL, PingOptions{Timeout: time.Second})
+	p.Reuse = true
+	defer p.Close()
+	for i := range 2 {
+		r, err := p.Probe(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.HTTP == nil || r.HTTP.StatusCode != http.StatusNoContent {
+			t.Fatalf("Probe() = %+v, want a 204 HTTP timing", r)
+		}
+		if r.HTTP.TTFB < 10*time.Millisecond || r.RTT != r.HTTP.Total || r.HTTP.Total < r.HTTP.TTFB {
+			t.Errorf("inconsistent timing: %+v", r.HTTP)
+		}
+		if reused := i > 0; r.HTTP.Reused != reused || (r.HTTP.Connect == 0) == !reused {
+			t.Errorf("probe %d: Reused = %v, Connect = %v", i, r.HTTP.Reused, r.HTTP.Connect)
+		}
+	}
+}
+
+func TestICMPProbeCanceled(t *testing.T) {
+	c, err := listen(true, PingOptions{Mode: SocketAuto})
+	if err != nil {
+		t.Skip(err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	// the replies of the loopback don't match the token: the probe waits until it's canceled
+	start := time.Now()
+	_, _, _, err = c.exchange(ctx, netip.MustParseAddr("127.0.0.1"), c.echoID(nextID()), 1, newToken(), payload(64, newToken()), 10*time.Second)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("exchange() error = %v, want %v", err, context.Canceled)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("exchange() returned after %v, not when canceled", d)
+	}
+
+	// canceled before the probe
+	if _, err = NewICMPProber("127.0.0.1", PingOptions{Mode: SocketAuto}).Probe(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Probe() error = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,393 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"nspeed.app/nspeed/network"
+)
+
+// SessionOptions are the options of a ping Session
+type SessionOptions struct {
+	PingOptions
+	Count    int           // number of probes to send, 0 = until Stop is called
+	Interval time.Duration // delay between probes, 0 = DefaultInterval
+}
+
+const (
+	DefaultInterval = time.Second     // delay between probes of a Session
+	DefaultTimeout  = 2 * time.Second // time to wait for a reply in a Session when no Timeout is set
+)
+
+// ProbeResult is the result of a probe of a Session, reported as soon as it is known
+type ProbeResult struct {
+	Seq      int           // sequence number of the probe, not wrapped (the first probe is 0)
+	Sent     time.Time     // time the echo request was sent
+	Received time.Time     // time the response was received, zero if lost
+	RTT      time.Duration // round trip time, 0 if lost
+	Peer     netip.Addr    // responder: the destination or a router for an ICMP error, invalid if lost
+	TTL      int           // TTL (IPv4) or hop limit (IPv6) of the response, 0 if unknown
+	// TrafficClass is the TOS (IPv4) or traffic class (IPv6) of the response, -1 if unknown.
+	// Most hosts reply with the marking of the request as received, for an ICMP error see ICMP.Quoted.TOS.
+	TrafficClass int
+	Bytes        int          // size of the received ICMP message
+	Response     PingResponse // kind of response, PingResponseNotHandled if lost
+	ICMP         *ICMPInfo    // decoded ICMP response, nil if lost
+	Duplicate    bool         // a reply to this probe was already received
+	Lost         bool         // no response within Timeout
+}
+
+// Session sends a series of ICMP echo requests to a single destination using a single socket
+// and computes statistics (see Statistics).
+//
+// On Unix platforms requires root or cap_net_raw capability unless options.Mode is
+// SocketUnprivileged or SocketAuto (see SocketMode).
+type Session struct {
+	options SessionOptions
+	addr    netip.Addr
+	isIPv4  bool
+	id      int
+	token   []byte // random token at the start of the echo payload
+	data    []byte // echo payload
+	conn    *conn
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	notify   chan struct{} // signaled on each response
+
+	mu      sync.Mutex
+	probes  map[int]*probe // in flight probes by Seq
+	lastSeq int            // highest sequence number (not wrapped) of received replies
+	stats   Statistics
+	running bool
+
+	reportMu sync.Mutex
+	report   func(ProbeResult)
+}
+
+// probe is an echo request in flight
+type probe struct {
+	seq     int // not wrapped sequence number
+	sent    time.Time
+	replied bool
+	failed  bool // an ICMP error was received instead of a reply
+}
+
+// NewSession resolves destination and opens the socket used by the Session.
+// The Session must be closed after use.
+func NewSession(destination string, options SessionOptions) (*Session, error) {
+	destAddr, err := network.Resolve(destination, options.Version)
+	if err != nil {
+		return nil, err
+	}
+	isIPv4 := destAddr.Is4() || destAddr.Is4In6()
+	if isIPv4 && options.Version == 6 {
+		return nil, fmt.Errorf("IP version mismatch")
+	}
+	c, err := listen(isIPv4, options.PingOptions)
+	if err != nil {
+		return nil, err
+	}
+	size := 0
+	if options.PacketSize > 0 {
+		size = int(options.PacketSize) - 1
+	}
+	token := newToken()
+	if options.Interval <= 0 {
+		options.Interval = DefaultInterval
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultTimeout
+	}
+	return &Session{
+		options: options,
+		addr:    destAddr.Unmap(),
+		isIPv4:  isIPv4,
+		id:      c.echoID(nextID()),
+		token:   token,
+		data:    payload(size, token),
+		conn:    c,
+		stop:    make(chan struct{}),
+		notify:  make(chan struct{}, 1),
+		probes:  make(map[int]*probe),
+		lastSeq: -1,
+	}, nil
+}
+
+// PingContext sends a series of ICMP echo requests to destination (see Session) until options.Count
+// probes are done or ctx is canceled, and calls report (if not nil) with the result of each probe.
+func PingContext(ctx context.Context, destination string, options SessionOptions, report func(ProbeResult)) (Statistics, error) {
+	s, err := NewSession(destination, options)
+	if err != nil {
+		return Statistics{}, err
+	}
+	defer func() {
+		_ = s.Close()
+	}()
+	return s.RunContext(ctx, report)
+}
+
+// Addr returns the resolved destination address
+func (s *Session) Addr() netip.Addr {
+	return s.addr
+}
+
+// Close closes the socket of the session
+func (s *Session) Close() error {
+	s.Stop()
+	return s.conn.Close()
+}
+
+// Stop stops sending probes. Run returns after waiting for the replies of probes in flight.
+func (s *Session) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+// Run sends the probes and waits for their replies. It can only be called once.
+func (s *Session) Run() (Statistics, error) {
+	return s.RunContext(context.Background(), nil)
+}
+
+// RunContext is like Run but returns as soon as ctx is canceled, without waiting for the replies
+// of probes in flight, with the statistics so far and ctx error.
+//
+// If report is not nil, it is called with the result of each probe as soon as it is known:
+// a reply (or a duplicate), an ICMP error or a loss after Timeout. The calls aren't concurrent
+// but come from the Session goroutines: report should return quickly.
+func (s *Session) RunContext(ctx context.Context, report func(ProbeResult)) (Statistics, error) {
+	s.mu.Lock()
+	if s.running {
+		s.mu.Unlock()
+		return Statistics{}, errors.New("session already run")
+	}
+	s.running = true
+	s.report = report
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.receive(done)
+	}()
+
+	start := time.Now()
+	ticker := time.NewTicker(s.options.Interval)
+	defer ticker.Stop()
+	expire := time.NewTimer(s.options.Timeout)
+	defer expire.Stop()
+	stop := s.stop
+	err := s.send(0)
+	n := 1
+	stopped := false
+loop:
+	for err == nil {
+		more := !stopped && (s.options.Count <= 0 || n < s.options.Count)
+		if !more && !s.pending() {
+			break
+		}
+		tick := ticker.C
+		if !more {
+			tick = nil
+		}
+		select {
+		case <-tick:
+			err = s.send(n)
+			n++
+		case <-stop:
+			stopped = true
+			stop = nil // closed, don't select it anymore
+		case <-s.notify:
+		case <-expire.C:
+			expire.Reset(s.expire(time.Now()))
+		case err = <-errc:
+			errc <- err
+		case <-ctx.Done():
+			err = ctx.Err()
+			break loop
+		}
+	}
+	close(done)
+	_ = s.conn.SetReadDeadline(time.Now())
+	if rerr := <-errc; err == nil {
+		err = rerr
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Elapsed = time.Since(start)
+	return s.stats.clone(), err
+}
+
+// send sends the nth probe
+func (s *Session) send(n int) error {
+	seq := n & 0xffff
+	wm := icmp.Message{
+		Type: s.conn.itype, Code: 0,
+		Body: &icmp.Echo{
+			ID:   s.id,
+			Seq:  seq,
+			Data: s.data,
+		},
+	}
+	wb, err := wm.Marshal(nil)
+	if err != nil {
+		return fmt.Errorf("message Marshal error: %w", err)
+	}
+
+	s.mu.Lock()
+	s.probes[seq] = &probe{seq: n, sent: time.Now()}
+	s.stats.Transmitted++
+	s.mu.Unlock()
+	_ = s.conn.SetWriteDeadline(time.Now().Add(s.options.Timeout))
+	if _, err = s.conn.writeTo(wb, s.addr); err != nil {
+		return fmt.Errorf("WriteTo error: %w", err)
+	}
+	return nil
+}
+
+// expire forgets the probes sent more than Timeout ago, their replies can't be valid anymore,
+// and reports the ones without response as lost.
+// It returns the delay until the next probe expires.
+func (s *Session) expire(now time.Time) time.Duration {
+	var lost []ProbeResult
+	next := s.options.Timeout
+	s.mu.Lock()
+	for k, p := range s.probes {
+		left := s.options.Timeout - now.Sub(p.sent)
+		if left > 0 {
+			next = min(next, left)
+			continue
+		}
+		if !p.replied && !p.failed {
+			lost = append(lost, ProbeResult{Seq: p.seq, Sent: p.sent, TrafficClass: -1, Lost: true})
+		}
+		delete(s.probes, k)
+	}
+	s.mu.Unlock()
+	slices.SortFunc(lost, func(a, b ProbeResult) int { return a.Seq - b.Seq })
+	for _, r := range lost {
+		s.notifyResult(r)
+	}
+	return next
+}
+
+// pending returns true if some probes in flight have no response
+func (s *Session) pending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.probes {
+		if !p.replied && !p.failed {
+			return true
+		}
+	}
+	return false
+}
+
+// receive reads responses until done is closed
+func (s *Session) receive(done chan struct{}) error {
+	rb := make([]byte, PacketSizeMax)
+	for {
+		n, pi, peer, err := s.conn.readFrom(rb)
+		now := time.Now()
+		select {
+		case <-done:
+			return nil
+		default:
+		}
+		if err != nil {
+			return fmt.Errorf("read error: %w", err)
+		}
+		r, err := parseReply(s.conn.protonumber, rb[:n])
+		if err != nil || !r.matches(s.id, s.token, s.addr) {
+			continue // not ours
+		}
+		if !r.dst.IsValid() && !isFrom(peer, s.addr) {
+			continue // echo reply from another host
+		}
+		info := r.info(peer)
+		result := ProbeResult{
+			Received:     now,
+			Peer:         info.From,
+			TTL:          pi.ttl,
+			TrafficClass: pi.tos,
+			Bytes:        n,
+			Response:     classify(r.message.Type),
+			ICMP:         info,
+		}
+		if r.dst.IsValid() {
+			s.icmpError(r.echo.Seq, result)
+			continue
+		}
+		s.reply(r.echo.Seq, result)
+	}
+}
+
+// icmpError records an ICMP error about probe seq
+func (s *Session) icmpError(seq int, result ProbeResult) {
+	s.mu.Lock()
+	p, ok := s.probes[seq]
+	if !ok || p.failed {
+		s.mu.Unlock()
+		return
+	}
+	p.failed = true
+	s.stats.Errors++
+	s.mu.Unlock()
+	result.Seq, result.Sent, result.RTT = p.seq, p.sent, result.Received.Sub(p.sent)
+	s.notifyResult(result)
+}
+
+// reply records the reply to probe seq
+func (s *Session) reply(seq int, result ProbeResult) {
+	s.mu.Lock()
+	p, ok := s.probes[seq]
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	rtt := result.Received.Sub(p.sent)
+	if rtt > s.options.Timeout {
+		s.mu.Unlock()
+		return // too late, this probe is lost
+	}
+	if p.replied {
+		s.stats.Duplicates++
+		result.Duplicate = true
+	} else {
+		p.replied = true
+		if p.seq < s.lastSeq {
+			s.stats.OutOfOrder++
+		} else {
+			s.lastSeq = p.seq
+		}
+		s.stats.add(rtt)
+	}
+	s.mu.Unlock()
+	result.Seq, result.Sent, result.RTT = p.seq, p.sent, rtt
+	s.notifyResult(result)
+}
+
+// notifyResult reports result and wakes up Run
+func (s *Session) notifyResult(result ProbeResult) {
+	if s.report != nil {
+		s.reportMu.Lock()
+		s.report(result)
+		s.reportMu.Unlock()
+	}
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
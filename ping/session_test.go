@@ -0,0 +1,60 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ping
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionResults(t *testing.T) {
+	var results []ProbeResult
+	s := &Session{
+		options: SessionOptions{PingOptions: PingOptions{Timeout: time.Second}},
+		notify:  make(chan struct{}, 1),
+		probes:  make(map[int]*probe),
+		lastSeq: -1,
+		report:  func(r ProbeResult) { results = append(results, r) },
+	}
+	start := time.Now()
+	for i := range 4 {
+		s.probes[i] = &probe{seq: i, sent: start.Add(time.Duration(i) * 100 * time.Millisecond)}
+	}
+	s.reply(1, ProbeResult{Received: start.Add(150 * time.Millisecond), TTL: 64})
+	s.reply(1, ProbeResult{Received: start.Add(160 * time.Millisecond)})
+	s.reply(0, ProbeResult{Received: start.Add(170 * time.Millisecond)})
+	s.icmpError(2, ProbeResult{Received: start.Add(220 * time.Millisecond), Response: PingResponseDestinationUnreachable})
+
+	if next := s.expire(start.Add(1050 * time.Millisecond)); next != 50*time.Millisecond {
+		t.Errorf("expire() = %v, want 50ms", next)
+	}
+	if len(s.probes) != 3 {
+		t.Errorf("%d probes left, want 3", len(s.probes))
+	}
+	s.expire(start.Add(2 * time.Second))
+	if len(s.probes) != 0 {
+		t.Errorf("%d probes left, want 0", len(s.probes))
+	}
+
+	want := []ProbeResult{
+		{Seq: 1, RTT: 50 * time.Millisecond, TTL: 64},
+		{Seq: 1, RTT: 60 * time.Millisecond, Duplicate: true},
+		{Seq: 0, RTT: 170 * time.Millisecond},
+		{Seq: 2, RTT: 20 * time.Millisecond, Response: PingResponseDestinationUnreachable},
+		{Seq: 3, Lost: true},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("%d results, want %d", len(results), len(want))
+	}
+	for i, w := range want {
+		r := results[i]
+		if r.Seq != w.Seq || r.RTT != w.RTT || r.TTL != w.TTL || r.Duplicate != w.Duplicate || r.Lost != w.Lost || r.Response != w.Response {
+			t.Errorf("result %d = %+v, want %+v", i, r, w)
+		}
+	}
+	if s.stats.Received != 2 || s.stats.Duplicates != 1 || s.stats.OutOfOrder != 1 || s.stats.Errors != 1 {
+		t.Errorf("stats = %+v", s.stats)
+	}
+}
@@ -0,0 +1,281 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"nspeed.app/nspeed/iana"
+	"nspeed.app/nspeed/network"
+)
+
+// SocketMode is the kind of ICMP socket used to send echo requests
+type SocketMode int
+
+const (
+	// SocketPrivileged uses a raw socket. On Unix platforms requires root or cap_net_raw capability.
+	SocketPrivileged SocketMode = 0
+	// SocketUnprivileged uses a datagram ICMP socket ("udp4"/"udp6"), available on Linux and Darwin.
+	// On Linux the group of the process must be in the net.ipv4.ping_group_range sysctl.
+	// The kernel replaces the echo ID by the local 'port' of the socket and only delivers
+	// the echo replies, ICMP errors (time exceeded, unreachable...) are not received.
+	SocketUnprivileged SocketMode = 1
+	// SocketAuto uses a raw socket if allowed, otherwise a datagram socket.
+	SocketAuto SocketMode = 2
+)
+
+var socketModeNames = map[SocketMode]string{
+	SocketPrivileged:   "privileged",
+	SocketUnprivileged: "unprivileged",
+	SocketAuto:         "auto",
+}
+
+func (m SocketMode) String() string {
+	n, ok := socketModeNames[m]
+	if ok {
+		return n
+	}
+	return "invalid"
+}
+
+// ParseSocketMode returns the SocketMode of name (as returned by SocketMode.String)
+func ParseSocketMode(name string) (SocketMode, error) {
+	for m, n := range socketModeNames {
+		if n == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid socket mode: %q", name)
+}
+
+// conn is an ICMP socket
+type conn struct {
+	net.PacketConn
+	privileged  bool      // raw socket
+	isIPv4      bool      // IPv4 or IPv6 socket
+	itype       icmp.Type // echo request type
+	protonumber int       // protocol number to parse the received messages
+	p4          *ipv4.PacketConn
+	p6          *ipv6.PacketConn
+	cm          bool // control messages are enabled (see readFrom)
+}
+
+// packetInfo is the information about a received packet read from its IP header or control messages
+type packetInfo struct {
+	ttl int // TTL (IPv4) or hop limit (IPv6), 0 if unknown
+	tos int // TOS (IPv4) or traffic class (IPv6), -1 if unknown
+}
+
+// listen opens an ICMP socket for the IP version and the socket mode of options
+// and sets its TTL/hop limit if options.HopLimit > 0.
+func listen(isIPv4 bool, options PingOptions) (*conn, error) {
+	switch options.Mode {
+	case SocketPrivileged:
+		return listenMode(isIPv4, true, options)
+	case SocketUnprivileged:
+		return listenMode(isIPv4, false, options)
+	case SocketAuto:
+		c, err := listenMode(isIPv4, true, options)
+		if err == nil {
+			return c, nil
+		}
+		c, err2 := listenMode(isIPv4, false, options)
+		if err2 != nil {
+			return nil, fmt.Errorf("%w (unprivileged: %w)", err, err2)
+		}
+		return c, nil
+	default:
+		return nil, fmt.Errorf("invalid socket mode: %d", options.Mode)
+	}
+}
+
+// listenMode opens a raw (privileged) or datagram ICMP socket
+func listenMode(isIPv4 bool, privileged bool, options PingOptions) (*conn, error) {
+	c := &conn{privileged: privileged, isIPv4: isIPv4}
+	network := "ip6:ipv6-icmp"
+	laddr := "::"
+	c.itype = ipv6.ICMPTypeEchoRequest
+	c.protonumber = iana.ProtocolIPv6ICMP
+	if isIPv4 {
+		network = "ip4:icmp"
+		laddr = "0.0.0.0"
+		c.itype = ipv4.ICMPTypeEcho
+		c.protonumber = iana.ProtocolICMP
+	}
+
+	if privileged {
+		// a raw socket is a net.IPConn, it allows to set socket options before use
+		var lc net.ListenConfig
+		if options.DontFragment {
+			lc.Control = func(network, address string, rc syscall.RawConn) error {
+				return setDontFragment(rc, isIPv4)
+			}
+		}
+		pc, err := lc.ListenPacket(context.Background(), network, laddr)
+		if err != nil {
+			return nil, fmt.Errorf("listen packet error: %w", err)
+		}
+		c.PacketConn = pc
+		if isIPv4 {
+			c.p4 = ipv4.NewPacketConn(pc)
+		} else {
+			c.p6 = ipv6.NewPacketConn(pc)
+		}
+	} else {
+		if options.DontFragment {
+			return nil, errors.New("don't fragment requires a privileged socket")
+		}
+		network = "udp6"
+		if isIPv4 {
+			network = "udp4"
+		}
+		pc, err := icmp.ListenPacket(network, laddr)
+		if err != nil {
+			return nil, fmt.Errorf("listen packet error: %w", err)
+		}
+		c.PacketConn = pc
+		c.p4 = pc.IPv4PacketConn()
+		c.p6 = pc.IPv6PacketConn()
+	}
+
+	if options.HopLimit > 0 {
+		if err := c.setHopLimit(options.HopLimit); err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+	}
+	if options.TrafficClass != 0 {
+		if err := c.setTrafficClass(options.TrafficClass); err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+	}
+	// not supported on all platforms, readFrom falls back to a plain read
+	if isIPv4 {
+		c.cm = c.p4.SetControlMessage(ipv4.FlagTTL, true) == nil
+	} else {
+		c.cm = c.p6.SetControlMessage(ipv6.FlagHopLimit|ipv6.FlagTrafficClass, true) == nil
+	}
+	return c, nil
+}
+
+// setTrafficClass sets the TOS (IPv4) or traffic class (IPv6) of the sent packets
+func (c *conn) setTrafficClass(tc network.TrafficClass) error {
+	var err error
+	if c.isIPv4 {
+		err = c.p4.SetTOS(int(tc))
+	} else {
+		err = c.p6.SetTrafficClass(int(tc))
+	}
+	if err != nil {
+		return fmt.Errorf("error setting traffic class: %w", err)
+	}
+	return nil
+}
+
+// readFrom reads an ICMP message (without IP header) into b
+// and returns the information about the received packet when available:
+// the TOS of IPv4 packets is only known with a raw socket (from the IP header).
+func (c *conn) readFrom(b []byte) (n int, info packetInfo, peer net.Addr, err error) {
+	info.tos = -1
+	if !c.cm {
+		n, peer, err = c.ReadFrom(b)
+		return
+	}
+	if c.isIPv4 {
+		if ipc, ok := c.PacketConn.(*net.IPConn); ok {
+			// unlike ReadFrom, ReadMsgIP doesn't strip the IPv4 header
+			var addr *net.IPAddr
+			n, _, _, addr, err = ipc.ReadMsgIP(b, nil)
+			if err != nil {
+				return 0, info, nil, err
+			}
+			peer = addr
+			if n >= ipv4.HeaderLen && b[0]>>4 == 4 {
+				if l := int(b[0]&0x0f) << 2; l >= ipv4.HeaderLen && l <= n {
+					info.tos, info.ttl = int(b[1]), int(b[8])
+					n = copy(b, b[l:n])
+				}
+			}
+			return
+		}
+		var cm *ipv4.ControlMessage
+		n, cm, peer, err = c.p4.ReadFrom(b)
+		if cm != nil {
+			info.ttl = cm.TTL
+		}
+		return
+	}
+	var cm *ipv6.ControlMessage
+	n, cm, peer, err = c.p6.ReadFrom(b)
+	if cm != nil {
+		info.ttl = cm.HopLimit
+		info.tos = cm.TrafficClass
+	}
+	return
+}
+
+// setHopLimit sets the TTL (IPv4) or hop limit (IPv6) of the sent packets
+func (c *conn) setHopLimit(hopLimit int) error {
+	var err error
+	if c.isIPv4 {
+		err = c.p4.SetTTL(hopLimit)
+	} else {
+		err = c.p6.SetHopLimit(hopLimit)
+	}
+	if err != nil {
+		return fmt.Errorf("error setting TTL: %w", err)
+	}
+	return nil
+}
+
+// echoID returns the echo ID the replies will carry when sending an echo request with id.
+// For a datagram socket this is the local port of the socket.
+func (c *conn) echoID(id int) int {
+	if c.privileged {
+		return id
+	}
+	if a, ok := c.LocalAddr().(*net.UDPAddr); ok {
+		return a.Port
+	}
+	return id
+}
+
+// writeTo sends b to dst
+func (c *conn) writeTo(b []byte, dst netip.Addr) (int, error) {
+	if c.privileged {
+		return c.WriteTo(b, &net.IPAddr{IP: dst.AsSlice()})
+	}
+	return c.WriteTo(b, &net.UDPAddr{IP: dst.AsSlice()})
+}
+
+// isFrom returns true if peer address is addr
+func isFrom(peer net.Addr, addr netip.Addr) bool {
+	a, ok := peerAddr(peer)
+	return ok && a == addr
+}
+
+// peerAddr returns the IP address of peer
+func peerAddr(peer net.Addr) (netip.Addr, bool) {
+	var ip net.IP
+	switch p := peer.(type) {
+	case *net.IPAddr:
+		ip = p.IP
+	case *net.UDPAddr:
+		ip = p.IP
+	default:
+		return netip.Addr{}, false
+	}
+	a, ok := netip.AddrFromSlice(ip)
+	return a.Unmap(), ok
+}
@@ -0,0 +1,103 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ping
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseSocketMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    SocketMode
+		wantErr bool
+	}{
+		{"privileged", SocketPrivileged, false},
+		{"unprivileged", SocketUnprivileged, false},
+		{"auto", SocketAuto, false},
+		{"raw", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSocketMode(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSocketMode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSocketMode() = %v, want %v", got, tt.want)
+			}
+			if err == nil && got.String() != tt.name {
+				t.Errorf("String() = %v, want %v", got.String(), tt.name)
+			}
+		})
+	}
+}
+
+// pingGroupAllowed reports whether the groups of the user are allowed to open unprivileged ICMP sockets
+// by net.ipv4.ping_group_range (Linux only, true elsewhere).
+func pingGroupAllowed() bool {
+	b, err := os.ReadFile("/proc/sys/net/ipv4/ping_group_range")
+	if err != nil {
+		return true
+	}
+	var lo, hi int
+	if _, err = fmt.Sscan(string(b), &lo, &hi); err != nil {
+		return true
+	}
+	groups, _ := os.Getgroups()
+	for _, g := range append(groups, os.Getgid()) {
+		if g >= lo && g <= hi {
+			return true
+		}
+	}
+	return false
+}
+
+func TestUnprivilegedLoopback(t *testing.T) {
+	if !pingGroupAllowed() {
+		t.Skip("unprivileged ICMP sockets not allowed by net.ipv4.ping_group_range")
+	}
+	options := PingOptions{Mode: SocketUnprivileged, Timeout: time.Second, PacketSize: 64}
+	c, err := listen(true, options)
+	if err != nil {
+		t.Skip(err)
+	}
+	c.Close()
+	loopback := netip.MustParseAddr("127.0.0.1")
+
+	peer, _, response, err := Ping("127.0.0.1", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response != PingResponseEchoReply || !isFrom(peer, loopback) {
+		t.Errorf("Ping() = %v, %v want %v from %v", peer, response, PingResponseEchoReply, loopback)
+	}
+
+	var results []ProbeResult
+	_, err = PingContext(context.Background(), "127.0.0.1", SessionOptions{PingOptions: options, Count: 1},
+		func(r ProbeResult) { results = append(results, r) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("%d results, want 1", len(results))
+	}
+	r := results[0]
+	if r.Lost || r.Response != PingResponseEchoReply || r.Peer != loopback {
+		t.Errorf("result = %+v, want an echo reply from %v", r, loopback)
+	}
+	// the datagram socket doesn't return the IP header: the TTL comes from the control messages
+	if r.TTL <= 0 {
+		t.Errorf("TTL = %d, want > 0", r.TTL)
+	}
+	if want := 8 + int(options.PacketSize) - 1; r.Bytes != want { // ICMP header and payload
+		t.Errorf("Bytes = %d, want %d", r.Bytes, want)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ping
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Statistics are the results of a ping Session, like the summary of iputils ping.
+type Statistics struct {
+	Transmitted int           // number of echo requests sent
+	Received    int           // number of distinct echo replies received
+	Duplicates  int           // number of duplicate echo replies
+	OutOfOrder  int           // number of echo replies received after a reply to a later request
+	Errors      int           // number of ICMP errors about our echo requests (unreachable, time exceeded, etc)
+	Elapsed     time.Duration // duration of the session
+
+	Min  time.Duration // minimum round trip time
+	Avg  time.Duration // average round trip time
+	Max  time.Duration // maximum round trip time
+	Mdev time.Duration // mean deviation of the round trip times (as in iputils ping)
+
+	Jitter Jitter // delay variation of the round trip times
+
+	sum  float64 // sum of rtt in ns
+	sum2 float64 // sum of squared rtt in ns
+}
+
+// add records the round trip time of a received (non duplicate) reply
+func (s *Statistics) add(rtt time.Duration) {
+	if s.Received == 0 || rtt < s.Min {
+		s.Min = rtt
+	}
+	if rtt > s.Max {
+		s.Max = rtt
+	}
+	s.Received++
+	s.Jitter.Add(rtt)
+	f := float64(rtt)
+	s.sum += f
+	s.sum2 += f * f
+	avg := s.sum / float64(s.Received)
+	s.Avg = time.Duration(avg)
+	// iputils: mdev = sqrt(sum(rtt^2)/n - avg^2)
+	s.Mdev = time.Duration(math.Sqrt(math.Max(s.sum2/float64(s.Received)-avg*avg, 0)))
+}
+
+// clone returns a copy of s which doesn't share the round trip times of its Jitter
+func (s Statistics) clone() Statistics {
+	s.Jitter = s.Jitter.clone()
+	return s
+}
+
+// PacketLoss returns the percentage of echo requests without reply (0 to 100).
+func (s Statistics) PacketLoss() float64 {
+	if s.Transmitted == 0 {
+		return 0
+	}
+	return float64(s.Transmitted-s.Received) * 100 / float64(s.Transmitted)
+}
+
+// String returns a summary in the same format as iputils ping.
+func (s Statistics) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d packets transmitted, %d received", s.Transmitted, s.Received)
+	if s.Duplicates > 0 {
+		fmt.Fprintf(&b, ", +%d duplicates", s.Duplicates)
+	}
+	if s.Errors > 0 {
+		fmt.Fprintf(&b, ", +%d errors", s.Errors)
+	}
+	if s.OutOfOrder > 0 {
+		fmt.Fprintf(&b, ", %d out of order", s.OutOfOrder)
+	}
+	fmt.Fprintf(&b, ", %.4g%% packet loss, time %dms", s.PacketLoss(), s.Elapsed.Milliseconds())
+	if s.Received > 0 {
+		fmt.Fprintf(&b, "\nrtt min/avg/max/mdev = %.3f/%.3f/%.3f/%.3f ms", ms(s.Min), ms(s.Avg), ms(s.Max), ms(s.Mdev))
+	}
+	return b.String()
+}
+
+// ms converts a duration to float milliseconds
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
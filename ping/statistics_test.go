@@ -0,0 +1,42 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ping
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatistics(t *testing.T) {
+	var s Statistics
+	s.Transmitted = 5
+	for _, rtt := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond, 40 * time.Millisecond} {
+		s.add(rtt)
+	}
+	if s.Received != 4 {
+		t.Errorf("Received = %d, want 4", s.Received)
+	}
+	if s.Min != 10*time.Millisecond || s.Max != 40*time.Millisecond || s.Avg != 25*time.Millisecond {
+		t.Errorf("min/avg/max = %v/%v/%v, want 10ms/25ms/40ms", s.Min, s.Avg, s.Max)
+	}
+	// sqrt((100+400+900+1600)/4 - 25*25) = sqrt(125) ms
+	if d := s.Mdev - 11180339*time.Nanosecond; d < -time.Microsecond || d > time.Microsecond {
+		t.Errorf("Mdev = %v, want ~11.18ms", s.Mdev)
+	}
+	if s.PacketLoss() != 20 {
+		t.Errorf("PacketLoss() = %v, want 20", s.PacketLoss())
+	}
+}
+
+func TestStatisticsNoTransmit(t *testing.T) {
+	var s Statistics
+	if s.PacketLoss() != 0 {
+		t.Errorf("PacketLoss() = %v, want 0", s.PacketLoss())
+	}
+	want := "0 packets transmitted, 0 received, 0% packet loss, time 0ms"
+	if s.String() != want {
+		t.Errorf("String() = %q, want %q", s.String(), want)
+	}
+}
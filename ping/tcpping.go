@@ -0,0 +1,71 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"syscall"
+	"time"
+
+	"nspeed.app/nspeed/network"
+)
+
+// TCPProber is a Prober measuring the duration of a TCP handshake (SYN -> SYN/ACK)
+// by connecting to a host:port. The connection is closed right after.
+type TCPProber struct {
+	Address string      // host:port, host can be a literal address or a DNS name
+	Options PingOptions // only Version, Timeout and TrafficClass are used
+}
+
+// NewTCPProber returns a Prober connecting to address (host:port)
+func NewTCPProber(address string, options PingOptions) *TCPProber {
+	return &TCPProber{Address: address, Options: options}
+}
+
+// Probe performs a single TCP connection. The DNS resolution isn't part of the round trip time.
+//
+// If the connection is refused (RST), the result has a valid RTT and
+// a PingResponseDestinationUnreachable response, as the host is reachable.
+func (p *TCPProber) Probe(ctx context.Context) (Result, error) {
+	host, port, err := net.SplitHostPort(p.Address)
+	if err != nil {
+		return Result{}, fmt.Errorf("split host port error: %w", err)
+	}
+	nport, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return Result{}, fmt.Errorf("invalid port: %s", port)
+	}
+	destAddr, err := network.Resolve(host, p.Options.Version)
+	if err != nil {
+		return Result{}, err
+	}
+	addr := netip.AddrPortFrom(destAddr.Unmap(), uint16(nport))
+	result := Result{Peer: net.TCPAddrFromAddrPort(addr)}
+
+	ctx, cancel := withTimeout(ctx, p.Options.Timeout)
+	defer cancel()
+	var d net.Dialer
+	if p.Options.TrafficClass != 0 {
+		d.Control = network.TrafficClassControl(p.Options.TrafficClass)
+	}
+	start := time.Now()
+	c, err := d.DialContext(ctx, "tcp", addr.String())
+	result.RTT = time.Since(start)
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		result.Response = PingResponseDestinationUnreachable
+		return result, nil
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	_ = c.Close()
+	result.Response = PingResponseEchoReply
+	return result, nil
+}
@@ -0,0 +1,547 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"nspeed.app/nspeed/iana"
+	"nspeed.app/nspeed/network"
+)
+
+// ProbeType is the kind of packet sent by Traceroute and MTR
+type ProbeType int
+
+const (
+	ProbeICMP ProbeType = 0 // ICMP echo request
+	ProbeUDP  ProbeType = 1 // UDP datagram to an unlikely used port (incremented for each probe)
+	ProbeTCP  ProbeType = 2 // TCP SYN (a TCP connection attempt)
+)
+
+var probeTypeNames = map[ProbeType]string{
+	ProbeICMP: "icmp",
+	ProbeUDP:  "udp",
+	ProbeTCP:  "tcp",
+}
+
+func (p ProbeType) String() string {
+	n, ok := probeTypeNames[p]
+	if ok {
+		return n
+	}
+	return "invalid"
+}
+
+// ParseProbeType returns the ProbeType of name (as returned by ProbeType.String)
+func ParseProbeType(name string) (ProbeType, error) {
+	for p, n := range probeTypeNames {
+		if n == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid probe type: %q", name)
+}
+
+const (
+	DefaultMaxHops = 30    // max TTL of Traceroute and MTR
+	DefaultQueries = 3     // number of probes per hop of Traceroute
+	DefaultUDPPort = 33434 // base destination port of UDP probes (as traceroute)
+	DefaultTCPPort = 80    // destination port of TCP probes
+)
+
+// TracerouteOptions are the options of Traceroute and MTR
+type TracerouteOptions struct {
+	Version    int           // IP version: 0,4 or 6
+	Probe      ProbeType     // kind of probe
+	Port       int           // destination port of UDP (base port) and TCP probes, 0 = DefaultUDPPort or DefaultTCPPort
+	FirstHop   int           // first TTL, 0 = 1
+	MaxHops    int           // max TTL, 0 = DefaultMaxHops
+	Queries    int           // number of probes per hop (Traceroute only), 0 = DefaultQueries
+	PacketSize uint16        // size of ICMP and UDP probes payload
+	Timeout    time.Duration // time to wait for a response, 0 = DefaultTimeout
+	Interval   time.Duration // delay between rounds (MTR only), 0 = DefaultInterval
+	Rounds     int           // number of rounds (MTR only), 0 = until the context is canceled
+}
+
+// HopProbe is the result of a single probe
+type HopProbe struct {
+	Peer     netip.Addr    // address of the responder, invalid if no response
+	RTT      time.Duration // round trip time
+	Response PingResponse  // kind of response (for TCP probes reaching the destination: PingResponseEchoReply)
+	ICMP     *ICMPInfo     // decoded ICMP response, nil if no response or for TCP probes reaching the destination
+}
+
+// Hop are the results of the probes sent with the same TTL
+type Hop struct {
+	TTL    int
+	Probes []HopProbe
+}
+
+// Trace is the result of Traceroute
+type Trace struct {
+	Destination netip.Addr
+	Reached     bool // the destination responded
+	Hops        []Hop
+}
+
+func (t *Trace) String() string {
+	var b strings.Builder
+	for _, h := range t.Hops {
+		fmt.Fprintf(&b, "%2d ", h.TTL)
+		var last netip.Addr
+		for _, p := range h.Probes {
+			if !p.Peer.IsValid() {
+				b.WriteString(" *")
+				continue
+			}
+			if p.Peer != last {
+				fmt.Fprintf(&b, " %s", p.Peer)
+				last = p.Peer
+			}
+			fmt.Fprintf(&b, "  %.3f ms", ms(p.RTT))
+			if p.Response != PingResponseEchoReply && p.Response != PingResponseTimeExceeded && p.Peer != t.Destination {
+				if p.ICMP != nil {
+					fmt.Fprintf(&b, " (%s)", p.ICMP.Reason)
+				} else {
+					fmt.Fprintf(&b, " (%s)", p.Response)
+				}
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// HopStatistics are the MTR statistics of a hop
+type HopStatistics struct {
+	TTL        int
+	Peers      []netip.Addr  // responders in order of appearance
+	Statistics               // Jitter.IPDV is the mean of the absolute differences between consecutive round trip times (mtr "Javg")
+	Last       time.Duration // last round trip time
+}
+
+// add records the result of a probe
+func (h *HopStatistics) add(p HopProbe) {
+	h.Transmitted++
+	if !p.Peer.IsValid() {
+		return
+	}
+	found := false
+	for _, a := range h.Peers {
+		if a == p.Peer {
+			found = true
+			break
+		}
+	}
+	if !found {
+		h.Peers = append(h.Peers, p.Peer)
+	}
+	h.Last = p.RTT
+	h.Statistics.add(p.RTT)
+}
+
+// Traceroute discovers the path to destination: for each TTL from options.FirstHop
+// it sends options.Queries probes and records the responders and the round trip times.
+// It stops at the TTL where the destination responds (or a router reports it as unreachable).
+//
+// It requires root or cap_net_raw capability (to receive the ICMP errors).
+func Traceroute(destination string, options TracerouteOptions) (*Trace, error) {
+	t, err := newTracer(destination, options)
+	if err != nil {
+		return nil, err
+	}
+	defer t.close()
+
+	trace := &Trace{Destination: t.dst}
+	hops := make([]Hop, t.options.MaxHops-t.options.FirstHop+1)
+	for i := range hops {
+		hops[i].TTL = t.options.FirstHop + i
+	}
+	last := t.options.MaxHops
+	for q := 0; q < t.options.Queries; q++ {
+		results, err := t.round(context.Background(), last)
+		if err != nil {
+			return nil, err
+		}
+		for i, p := range results {
+			hops[i].Probes = append(hops[i].Probes, p)
+			if t.isFinal(p) && hops[i].TTL < last {
+				last = hops[i].TTL
+			}
+		}
+	}
+	trace.Hops = hops[:last-t.options.FirstHop+1]
+	for _, p := range trace.Hops[len(trace.Hops)-1].Probes {
+		if p.Peer == t.dst {
+			trace.Reached = true
+		}
+	}
+	return trace, nil
+}
+
+// MTR continuously probes the path to destination (like mtr): every options.Interval
+// it sends a probe per TTL and updates the per hop statistics.
+// report, if not nil, is called with the statistics after each round, they are updated in place by the next round.
+// It runs for options.Rounds rounds or until ctx is canceled and returns the final statistics.
+//
+// It requires root or cap_net_raw capability (to receive the ICMP errors).
+func MTR(ctx context.Context, destination string, options TracerouteOptions, report func([]HopStatistics)) ([]HopStatistics, error) {
+	t, err := newTracer(destination, options)
+	if err != nil {
+		return nil, err
+	}
+	defer t.close()
+
+	stats := make([]HopStatistics, t.options.MaxHops-t.options.FirstHop+1)
+	for i := range stats {
+		stats[i].TTL = t.options.FirstHop + i
+	}
+	last := t.options.MaxHops
+	ticker := time.NewTicker(t.options.Interval)
+	defer ticker.Stop()
+	for n := 0; t.options.Rounds <= 0 || n < t.options.Rounds; n++ {
+		if n > 0 {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return stats[:last-t.options.FirstHop+1], nil
+			}
+		}
+		results, err := t.round(ctx, last)
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i, p := range results {
+			stats[i].add(p)
+			if t.isFinal(p) && stats[i].TTL < last {
+				last = stats[i].TTL
+			}
+		}
+		if report != nil {
+			report(stats[:last-t.options.FirstHop+1])
+		}
+	}
+	return stats[:last-t.options.FirstHop+1], nil
+}
+
+// tracer sends probes with increasing TTL and matches the responses
+type tracer struct {
+	options TracerouteOptions
+	dst     netip.Addr
+	isIPv4  bool
+	icmp    *conn        // raw ICMP socket receiving the responses
+	udp     *net.UDPConn // socket of UDP probes
+	id      int          // echo ID of ICMP probes
+	token   []byte
+	data    []byte // payload of ICMP and UDP probes
+	n       int    // number of probes sent
+
+	mu     sync.Mutex
+	probes map[int]*traceProbe // in flight probes by echo Seq, UDP destination port or TCP source port
+}
+
+// traceProbe is a probe in flight
+type traceProbe struct {
+	sent   time.Time
+	result chan HopProbe // the first result wins
+	cancel context.CancelFunc
+}
+
+// done records the result of the probe
+func (p *traceProbe) done(r HopProbe) {
+	select {
+	case p.result <- r:
+	default:
+	}
+	if p.cancel != nil {
+		p.cancel()
+	}
+}
+
+func newTracer(destination string, options TracerouteOptions) (*tracer, error) {
+	if options.Probe != ProbeICMP && options.Probe != ProbeUDP && options.Probe != ProbeTCP {
+		return nil, fmt.Errorf("invalid probe type: %d", options.Probe)
+	}
+	if options.Port == 0 {
+		options.Port = DefaultUDPPort
+		if options.Probe == ProbeTCP {
+			options.Port = DefaultTCPPort
+		}
+	}
+	if options.Port < 1 || options.Port > 0xffff {
+		return nil, fmt.Errorf("invalid port: %d", options.Port)
+	}
+	if options.FirstHop <= 0 {
+		options.FirstHop = 1
+	}
+	if options.MaxHops <= 0 {
+		options.MaxHops = DefaultMaxHops
+	}
+	if options.FirstHop > options.MaxHops {
+		return nil, fmt.Errorf("first hop (%d) is greater than max hops (%d)", options.FirstHop, options.MaxHops)
+	}
+	if options.Queries <= 0 {
+		options.Queries = DefaultQueries
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultTimeout
+	}
+	if options.Interval <= 0 {
+		options.Interval = DefaultInterval
+	}
+
+	destAddr, err := network.Resolve(destination, options.Version)
+	if err != nil {
+		return nil, err
+	}
+	isIPv4 := destAddr.Is4() || destAddr.Is4In6()
+	if isIPv4 && options.Version == 6 {
+		return nil, fmt.Errorf("IP version mismatch")
+	}
+
+	c, err := listen(isIPv4, PingOptions{Mode: SocketPrivileged})
+	if err != nil {
+		return nil, err
+	}
+	size := 0
+	if options.PacketSize > 0 {
+		size = int(options.PacketSize) - 1
+	}
+	t := &tracer{
+		options: options,
+		dst:     destAddr.Unmap(),
+		isIPv4:  isIPv4,
+		icmp:    c,
+		id:      nextID(),
+		token:   newToken(),
+		probes:  make(map[int]*traceProbe),
+	}
+	t.data = payload(size, t.token)
+	if options.Probe == ProbeUDP {
+		network := "udp6"
+		if isIPv4 {
+			network = "udp4"
+		}
+		t.udp, err = net.ListenUDP(network, nil)
+		if err != nil {
+			_ = c.Close()
+			return nil, fmt.Errorf("listen udp error: %w", err)
+		}
+	}
+	go t.receive()
+	return t, nil
+}
+
+func (t *tracer) close() {
+	_ = t.icmp.Close()
+	if t.udp != nil {
+		_ = t.udp.Close()
+	}
+}
+
+// isFinal returns true if no hop after the responder of p is reachable
+func (t *tracer) isFinal(p HopProbe) bool {
+	return p.Peer == t.dst || p.Response == PingResponseDestinationUnreachable
+}
+
+// round sends a probe for each TTL up to last and returns the results indexed by TTL-FirstHop
+func (t *tracer) round(ctx context.Context, last int) ([]HopProbe, error) {
+	probes := make([]*traceProbe, last-t.options.FirstHop+1)
+	for i := range probes {
+		p, err := t.send(ctx, t.options.FirstHop+i)
+		if err != nil {
+			return nil, err
+		}
+		probes[i] = p
+	}
+	results := make([]HopProbe, len(probes))
+	deadline := time.NewTimer(t.options.Timeout)
+	defer deadline.Stop()
+	var err error
+	expired := false
+wait:
+	for i, p := range probes {
+		select {
+		case results[i] = <-p.result:
+			continue
+		default:
+		}
+		if expired {
+			continue // no response
+		}
+		select {
+		case results[i] = <-p.result:
+		case <-deadline.C:
+			expired = true
+		case <-ctx.Done():
+			err = ctx.Err()
+			break wait
+		}
+	}
+	t.mu.Lock()
+	for k, p := range t.probes {
+		if p.cancel != nil {
+			p.cancel()
+		}
+		delete(t.probes, k)
+	}
+	t.mu.Unlock()
+	return results, err
+}
+
+// send sends a probe with this ttl
+func (t *tracer) send(ctx context.Context, ttl int) (*traceProbe, error) {
+	p := &traceProbe{result: make(chan HopProbe, 1)}
+	t.n++
+	switch t.options.Probe {
+	case ProbeICMP:
+		seq := t.n & 0xffff
+		wm := icmp.Message{
+			Type: t.icmp.itype, Code: 0,
+			Body: &icmp.Echo{ID: t.id, Seq: seq, Data: t.data},
+		}
+		wb, err := wm.Marshal(nil)
+		if err != nil {
+			return nil, fmt.Errorf("message Marshal error: %w", err)
+		}
+		if err = t.icmp.setHopLimit(ttl); err != nil {
+			return nil, err
+		}
+		t.register(seq, p)
+		if _, err = t.icmp.writeTo(wb, t.dst); err != nil {
+			return nil, fmt.Errorf("WriteTo error: %w", err)
+		}
+	case ProbeUDP:
+		port := t.options.Port + (t.n-1)%(0x10000-t.options.Port)
+		var err error
+		if t.isIPv4 {
+			err = ipv4.NewPacketConn(t.udp).SetTTL(ttl)
+		} else {
+			err = ipv6.NewPacketConn(t.udp).SetHopLimit(ttl)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error setting TTL: %w", err)
+		}
+		t.register(port, p)
+		if _, err = t.udp.WriteToUDPAddrPort(t.data, netip.AddrPortFrom(t.dst, uint16(port))); err != nil {
+			return nil, fmt.Errorf("WriteTo error: %w", err)
+		}
+	case ProbeTCP:
+		ctx, cancel := context.WithTimeout(ctx, t.options.Timeout)
+		p.cancel = cancel
+		ready := make(chan error, 1)
+		go t.dialTCP(ctx, ttl, p, ready)
+		if err := <-ready; err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// register records a probe in flight and its send time
+func (t *tracer) register(key int, p *traceProbe) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p.sent = time.Now()
+	t.probes[key] = p
+}
+
+// dialTCP sends a TCP SYN with this ttl by connecting to the destination.
+// ready receives the result of the socket setup, before the SYN is sent.
+func (t *tracer) dialTCP(ctx context.Context, ttl int, p *traceProbe, ready chan<- error) {
+	network := "tcp6"
+	if t.isIPv4 {
+		network = "tcp4"
+	}
+	controlled := false
+	d := net.Dialer{
+		Control: func(network, address string, c syscall.RawConn) error {
+			controlled = true
+			port, err := tcpProbeControl(c, t.isIPv4, ttl)
+			if err == nil {
+				t.register(port, p)
+			}
+			ready <- err
+			return err
+		},
+	}
+	conn, err := d.DialContext(ctx, network, netip.AddrPortFrom(t.dst, uint16(t.options.Port)).String())
+	now := time.Now()
+	if err == nil {
+		_ = conn.Close()
+	}
+	if !controlled {
+		ready <- err
+		return
+	}
+	// connected or refused (RST): the destination is reached
+	if err == nil || errors.Is(err, syscall.ECONNREFUSED) {
+		p.done(HopProbe{Peer: t.dst, RTT: now.Sub(p.sent), Response: PingResponseEchoReply})
+	}
+}
+
+// receive reads the responses of the probes until the socket is closed
+func (t *tracer) receive() {
+	rb := make([]byte, PacketSizeMax)
+	for {
+		n, peer, err := t.icmp.ReadFrom(rb)
+		now := time.Now()
+		if err != nil {
+			return
+		}
+		r, err := parseReply(t.icmp.protonumber, rb[:n])
+		if err != nil {
+			continue
+		}
+		key := -1
+		switch t.options.Probe {
+		case ProbeICMP:
+			if r.matches(t.id, t.token, t.dst) && (r.dst.IsValid() || isFrom(peer, t.dst)) {
+				key = r.echo.Seq
+			}
+		case ProbeUDP, ProbeTCP:
+			src, dst, ok := r.ports()
+			if !ok || r.dst != t.dst {
+				continue
+			}
+			want := iana.ProtocolUDP
+			if t.options.Probe == ProbeTCP {
+				want = iana.ProtocolTCP
+			}
+			if r.protocol != want {
+				continue
+			}
+			if t.options.Probe == ProbeUDP && src == t.udp.LocalAddr().(*net.UDPAddr).Port {
+				key = dst
+			}
+			if t.options.Probe == ProbeTCP && dst == t.options.Port {
+				key = src
+			}
+		}
+		t.mu.Lock()
+		p, ok := t.probes[key]
+		t.mu.Unlock()
+		if !ok {
+			continue
+		}
+		info := r.info(peer)
+		p.done(HopProbe{Peer: info.From, RTT: now.Sub(p.sent), Response: classify(r.message.Type), ICMP: info})
+	}
+}
@@ -0,0 +1,17 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !unix
+
+package ping
+
+import (
+	"errors"
+	"syscall"
+)
+
+// tcpProbeControl is not supported on this platform
+func tcpProbeControl(c syscall.RawConn, isIPv4 bool, ttl int) (port int, err error) {
+	return 0, errors.New("TCP probes are not supported on this platform")
+}
@@ -0,0 +1,58 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ping
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestParseProbeType(t *testing.T) {
+	for _, p := range []ProbeType{ProbeICMP, ProbeUDP, ProbeTCP} {
+		got, err := ParseProbeType(p.String())
+		if err != nil || got != p {
+			t.Errorf("ParseProbeType(%q) = %v, %v want %v", p.String(), got, err, p)
+		}
+	}
+	if _, err := ParseProbeType("sctp"); err == nil {
+		t.Error("ParseProbeType(\"sctp\") should fail")
+	}
+}
+
+func TestHopStatistics(t *testing.T) {
+	a := netip.MustParseAddr("192.0.2.1")
+	b := netip.MustParseAddr("192.0.2.2")
+	var h HopStatistics
+	for _, p := range []HopProbe{
+		{Peer: a, RTT: 10 * time.Millisecond},
+		{}, // lost
+		{Peer: b, RTT: 14 * time.Millisecond},
+		{Peer: a, RTT: 12 * time.Millisecond},
+	} {
+		h.add(p)
+	}
+	if h.Transmitted != 4 || h.Received != 3 {
+		t.Errorf("Transmitted, Received = %d, %d want 4, 3", h.Transmitted, h.Received)
+	}
+	if len(h.Peers) != 2 || h.Peers[0] != a || h.Peers[1] != b {
+		t.Errorf("Peers = %v, want [%v %v]", h.Peers, a, b)
+	}
+	if h.Last != 12*time.Millisecond {
+		t.Errorf("Last = %v, want 12ms", h.Last)
+	}
+	// (|14-10| + |12-14|) / 2
+	if h.Jitter.IPDV != 3*time.Millisecond {
+		t.Errorf("Jitter.IPDV = %v, want 3ms", h.Jitter.IPDV)
+	}
+}
+
+func TestTracerouteInvalidPort(t *testing.T) {
+	for _, port := range []int{-1, 0x10000, 100000} {
+		if _, err := Traceroute("127.0.0.1", TracerouteOptions{Probe: ProbeUDP, Port: port}); err == nil {
+			t.Errorf("Traceroute() with port %d should fail", port)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright (c) Jean-Francois Giorgi & AUTHORS
+// part of nspeed.app
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build unix
+
+package ping
+
+import (
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// tcpProbeControl sets the TTL of a TCP socket and binds it to get its source port before the connection.
+func tcpProbeControl(c syscall.RawConn, isIPv4 bool, ttl int) (port int, err error) {
+	cerr := c.Control(func(fd uintptr) {
+		var sa unix.Sockaddr = &unix.SockaddrInet4{}
+		if isIPv4 {
+			err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TTL, ttl)
+		} else {
+			err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_UNICAST_HOPS, ttl)
+			sa = &unix.SockaddrInet6{}
+		}
+		if err != nil {
+			err = fmt.Errorf("error setting TTL: %w", err)
+			return
+		}
+		if err = unix.Bind(int(fd), sa); err != nil {
+			err = fmt.Errorf("bind error: %w", err)
+			return
+		}
+		sa, err = unix.Getsockname(int(fd))
+		if err != nil {
+			return
+		}
+		switch a := sa.(type) {
+		case *unix.SockaddrInet4:
+			port = a.Port
+		case *unix.SockaddrInet6:
+			port = a.Port
+		}
+	})
+	if cerr != nil {
+		return 0, cerr
+	}
+	return port, err
+}